@@ -0,0 +1,618 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/sirupsen/logrus"
+)
+
+//defaults used when the environment does not provide a value
+const (
+	DefaultAppName = "POC"
+	DefaultLicense = "acb54af7704d14c310b831563bb78b855a01NRAL"
+	//time given to in-flight requests and the final harvest on exit
+	DefaultShutdownTimeout = 10 * time.Second
+	//Apdex T of the agent, used when APDEX_T is not set
+	DefaultApdexThreshold = 500 * time.Millisecond
+	//deadline for handling a single request
+	DefaultRequestTimeout = 30 * time.Second
+	//time waited at startup for the agent to connect to New Relic
+	DefaultConnectTimeout = 5 * time.Second
+	//deployment environment when APP_ENV is not set
+	DefaultEnvironment = "development"
+	//port used when neither -port nor PORT is given
+	DefaultPort = "8000"
+	//path prefixes ignored when NEW_RELIC_IGNORE_PATHS is not set
+	DefaultIgnorePaths = "/ignore"
+	//attributes never sent when NEW_RELIC_ATTRIBUTES_EXCLUDE is not set
+	DefaultAttributesExclude = "request.headers.authorization,request.headers.cookie"
+	//events kept per harvest cycle, the agent's own defaults
+	DefaultMaxTxnEvents  = 10000
+	DefaultMaxSpanEvents = 2000
+	//bytes of an error response body kept as the response.error_body attribute
+	DefaultErrorBodyLimit = 1024
+	//requests per second and burst allowed through /limited
+	DefaultRateLimit = 5.0
+	DefaultRateBurst = 10
+	//largest request body accepted by every route but /upload
+	DefaultMaxBodyBytes = 1 << 20
+	//largest body accepted by /upload
+	DefaultUploadLimit = 10 << 20
+	//requests slower than this are reported as slow_request events, in milliseconds
+	DefaultSlowRequestMs = 1000
+	//size of the background worker pool and of its queue
+	DefaultWorkers     = 4
+	DefaultWorkerQueue = 100
+	//URLs called in turn by /ping-downstream when DOWNSTREAM_URLS is not set
+	DefaultDownstreamURLs = "https://api.github.com,https://api.github.com/zen,https://api.github.com/users/defunkt"
+	//responses of POST /orders kept for replay by Idempotency-Key, and for how long
+	DefaultIdempotencyCacheSize = 1000
+	DefaultIdempotencyTTL       = 10 * time.Minute
+	//attempts made by /external before giving up
+	DefaultExternalMaxAttempts = 3
+)
+
+//values accepted by TELEMETRY_MODE
+const (
+	//TelemetryNewRelic instruments gin with the native agent through nrgin
+	TelemetryNewRelic = "newrelic"
+	//TelemetryOTel instruments gin with otelgin and exports spans over OTLP
+	TelemetryOTel = "otel"
+)
+
+//values accepted by LOG_FORMAT
+const (
+	//LogFormatText writes logfmt-style key=value lines
+	LogFormatText = "text"
+	//LogFormatJSON writes one JSON object per line
+	LogFormatJSON = "json"
+)
+
+//Config holds the application settings resolved at startup
+type Config struct {
+	//Enabled is false when NEW_RELIC_ENABLED=false, the agent then reports nothing and needs no license
+	Enabled bool
+	//Host is the collector host from NEW_RELIC_HOST, such as an EU endpoint or a proxy, empty for the agent's default
+	Host string
+	//AppName is the New Relic application name, from NEW_RELIC_APP_NAME
+	AppName string
+	//License is the New Relic license key, from NEW_RELIC_LICENSE_KEY
+	License string
+	//Environment is the deployment environment from APP_ENV, e.g. development, staging or production
+	Environment string
+	//SecondaryLicense is a second account's license key from NEW_RELIC_SECONDARY_LICENSE_KEY, empty to report to one account
+	SecondaryLicense string
+	//Addr is the address the HTTP server listens on
+	Addr string
+	//GRPCAddr is the address of the gRPC server from GRPC_PORT, empty when it is disabled
+	GRPCAddr string
+	//ShutdownTimeout bounds the server drain and the final New Relic harvest
+	ShutdownTimeout time.Duration
+	//RequestTimeout is the deadline put on every request context
+	RequestTimeout time.Duration
+	//SlowRequestThreshold is the duration above which a request is reported as slow
+	SlowRequestThreshold time.Duration
+	//ApdexThreshold is the Apdex T applied to routes without an override, from APDEX_T
+	ApdexThreshold time.Duration
+	//RouteApdex overrides ApdexThreshold per route template, from APDEX_T_ROUTES such as "/slow=2s,/external=1s"
+	RouteApdex map[string]time.Duration
+	//ConnectTimeout bounds the startup wait for the agent to connect
+	ConnectTimeout time.Duration
+	//DatabaseURL is the MySQL DSN, empty when no database is configured
+	DatabaseURL string
+	//PostgresURL is the PostgreSQL connection string, empty when no PostgreSQL is configured
+	PostgresURL string
+	//RedisURL is the Redis address as a redis:// URL, empty when no cache is configured
+	RedisURL string
+	//JWTSecret is the HS256 key bearer tokens are verified with, from JWT_SECRET, empty to skip verification
+	JWTSecret string
+	//RunSelfTest records a startup-selftest transaction once connected, from RUN_SELFTEST
+	RunSelfTest bool
+	//AllowTxnNameOverride lets an X-Transaction-Name header rename the transaction, from ALLOW_TXN_NAME_OVERRIDE
+	AllowTxnNameOverride bool
+	//DebugRoutes exposes developer utility endpoints, never enable it in production
+	DebugRoutes bool
+	//DistributedTracing turns the agent's distributed tracer on or off
+	DistributedTracing bool
+	//MaxTxnEvents caps the transaction events kept per harvest, from NEW_RELIC_MAX_TXN_EVENTS
+	MaxTxnEvents int
+	//MaxSpanEvents caps the span events kept per harvest, from NEW_RELIC_MAX_SPAN_EVENTS
+	MaxSpanEvents int
+	//HighSecurity enables High Security Mode, which drops custom attributes and events
+	HighSecurity bool
+	//CustomEvents turns custom event recording on or off, from NEW_RELIC_CUSTOM_EVENTS_ENABLED
+	CustomEvents bool
+	//AttributesExclude are attribute keys, or prefixes ending in *, dropped from every destination
+	AttributesExclude []string
+	//LogLevel is the lowest level logged, from LOG_LEVEL
+	LogLevel logrus.Level
+	//LogFormat is LogFormatText or LogFormatJSON, from LOG_FORMAT
+	LogFormat string
+	//TelemetryMode selects how gin routes are instrumented, TelemetryNewRelic or TelemetryOTel
+	TelemetryMode string
+	//DisabledRoutes are the routes, e.g. /panic or "PUT /cache/:key", left unregistered, from DISABLED_ROUTES
+	DisabledRoutes []string
+	//TrustedProxies are the IPs and CIDRs whose X-Forwarded-For is believed, from TRUSTED_PROXIES; empty trusts none, "*" all
+	TrustedProxies []string
+	//IgnorePaths are lower-cased path prefixes whose transactions are never reported
+	IgnorePaths []string
+	//ErrorBodyLimit is how many bytes of a >= 400 response body are recorded, 0 disables it
+	ErrorBodyLimit int
+	//RateLimit is the sustained requests per second allowed through /limited
+	RateLimit float64
+	//RateBurst is how many requests /limited accepts at once before throttling
+	RateBurst int
+	//IdempotencyCacheSize is the number of responses kept for Idempotency-Key replays, from IDEMPOTENCY_CACHE_SIZE
+	IdempotencyCacheSize int
+	//IdempotencyTTL is how long a response is replayed for its Idempotency-Key, from IDEMPOTENCY_TTL
+	IdempotencyTTL time.Duration
+	//MaxBodyBytes is the largest request body accepted outside /upload, from MAX_BODY_BYTES
+	MaxBodyBytes int
+	//UploadLimit is the largest request body /upload accepts, in bytes
+	UploadLimit int
+	//DownstreamURLs are called one after the other by /ping-downstream, from DOWNSTREAM_URLS
+	DownstreamURLs []string
+	//ExternalMaxAttempts bounds how many times /external tries the upstream call
+	ExternalMaxAttempts int
+	//Workers is the number of background workers reading the job queue
+	Workers int
+	//WorkerQueue is how many jobs can wait before /enqueue pushes back
+	WorkerQueue int
+	//UploadDir is where /upload saves files, the system temp dir unless UPLOAD_DIR is set
+	UploadDir string
+}
+
+//Load resolves the application settings for the given port from the environment
+func Load(port string) (Config, error) {
+	addr, err := ListenAddr(port)
+	if err != nil {
+		return Config{}, err
+	}
+	var grpcAddr string
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		if grpcAddr, err = ListenAddr(grpcPort); err != nil {
+			return Config{}, err
+		}
+	}
+	host, err := CollectorHost(os.Getenv("NEW_RELIC_HOST"))
+	if err != nil {
+		return Config{}, err
+	}
+	timeout, err := ShutdownTimeout()
+	if err != nil {
+		return Config{}, err
+	}
+	connectTimeout, err := GetDuration("NEW_RELIC_CONNECT_TIMEOUT", DefaultConnectTimeout)
+	if err != nil {
+		return Config{}, err
+	}
+	requestTimeout, err := GetDuration("REQUEST_TIMEOUT", DefaultRequestTimeout)
+	if err != nil {
+		return Config{}, err
+	}
+	apdexThreshold, err := GetDuration("APDEX_T", DefaultApdexThreshold)
+	if err != nil {
+		return Config{}, err
+	}
+	routeApdex, err := GetDurationMap("APDEX_T_ROUTES")
+	if err != nil {
+		return Config{}, err
+	}
+	slowRequestMs, err := GetInt("SLOW_REQUEST_MS", DefaultSlowRequestMs)
+	if err != nil || slowRequestMs < 0 {
+		return Config{}, fmt.Errorf("invalid SLOW_REQUEST_MS value: %s", os.Getenv("SLOW_REQUEST_MS"))
+	}
+	enabled, err := GetBool("NEW_RELIC_ENABLED", true)
+	if err != nil {
+		return Config{}, err
+	}
+	runSelfTest, err := GetBool("RUN_SELFTEST", false)
+	if err != nil {
+		return Config{}, err
+	}
+	allowTxnNameOverride, err := GetBool("ALLOW_TXN_NAME_OVERRIDE", false)
+	if err != nil {
+		return Config{}, err
+	}
+	debugRoutes, err := GetBool("ENABLE_DEBUG_ROUTES", false)
+	if err != nil {
+		return Config{}, err
+	}
+	distributedTracing, err := GetBool("NEW_RELIC_DISTRIBUTED_TRACING_ENABLED", true)
+	if err != nil {
+		return Config{}, err
+	}
+	maxTxnEvents, err := GetInt("NEW_RELIC_MAX_TXN_EVENTS", DefaultMaxTxnEvents)
+	if err != nil || maxTxnEvents < 0 {
+		return Config{}, fmt.Errorf("invalid NEW_RELIC_MAX_TXN_EVENTS value: %s", os.Getenv("NEW_RELIC_MAX_TXN_EVENTS"))
+	}
+	maxSpanEvents, err := GetInt("NEW_RELIC_MAX_SPAN_EVENTS", DefaultMaxSpanEvents)
+	if err != nil || maxSpanEvents < 0 {
+		return Config{}, fmt.Errorf("invalid NEW_RELIC_MAX_SPAN_EVENTS value: %s", os.Getenv("NEW_RELIC_MAX_SPAN_EVENTS"))
+	}
+	highSecurity, err := GetBool("NEW_RELIC_HIGH_SECURITY", false)
+	if err != nil {
+		return Config{}, err
+	}
+	customEvents, err := GetBool("NEW_RELIC_CUSTOM_EVENTS_ENABLED", true)
+	if err != nil {
+		return Config{}, err
+	}
+	errorBodyLimit, err := GetInt("RESPONSE_ERROR_BODY_BYTES", DefaultErrorBodyLimit)
+	if err != nil || errorBodyLimit < 0 {
+		return Config{}, fmt.Errorf("invalid RESPONSE_ERROR_BODY_BYTES value: %s", os.Getenv("RESPONSE_ERROR_BODY_BYTES"))
+	}
+	rateLimit, err := GetFloat("RATE_LIMIT_RPS", DefaultRateLimit)
+	if err != nil || rateLimit <= 0 {
+		return Config{}, fmt.Errorf("invalid RATE_LIMIT_RPS value: %s", os.Getenv("RATE_LIMIT_RPS"))
+	}
+	rateBurst, err := GetInt("RATE_LIMIT_BURST", DefaultRateBurst)
+	if err != nil || rateBurst < 1 {
+		return Config{}, fmt.Errorf("invalid RATE_LIMIT_BURST value: %s", os.Getenv("RATE_LIMIT_BURST"))
+	}
+	maxBodyBytes, err := GetInt("MAX_BODY_BYTES", DefaultMaxBodyBytes)
+	if err != nil || maxBodyBytes < 1 {
+		return Config{}, fmt.Errorf("invalid MAX_BODY_BYTES value: %s", os.Getenv("MAX_BODY_BYTES"))
+	}
+	idempotencyCacheSize, err := GetInt("IDEMPOTENCY_CACHE_SIZE", DefaultIdempotencyCacheSize)
+	if err != nil || idempotencyCacheSize < 1 {
+		return Config{}, fmt.Errorf("invalid IDEMPOTENCY_CACHE_SIZE value: %s", os.Getenv("IDEMPOTENCY_CACHE_SIZE"))
+	}
+	idempotencyTTL, err := GetDuration("IDEMPOTENCY_TTL", DefaultIdempotencyTTL)
+	if err != nil {
+		return Config{}, err
+	}
+	uploadLimit, err := GetInt("UPLOAD_MAX_BYTES", DefaultUploadLimit)
+	if err != nil || uploadLimit < 1 {
+		return Config{}, fmt.Errorf("invalid UPLOAD_MAX_BYTES value: %s", os.Getenv("UPLOAD_MAX_BYTES"))
+	}
+	externalMaxAttempts, err := GetInt("EXTERNAL_MAX_ATTEMPTS", DefaultExternalMaxAttempts)
+	if err != nil || externalMaxAttempts < 1 {
+		return Config{}, fmt.Errorf("invalid EXTERNAL_MAX_ATTEMPTS value: %s", os.Getenv("EXTERNAL_MAX_ATTEMPTS"))
+	}
+	workers, err := GetInt("WORKER_COUNT", DefaultWorkers)
+	if err != nil || workers < 1 {
+		return Config{}, fmt.Errorf("invalid WORKER_COUNT value: %s", os.Getenv("WORKER_COUNT"))
+	}
+	workerQueue, err := GetInt("WORKER_QUEUE_SIZE", DefaultWorkerQueue)
+	if err != nil || workerQueue < 1 {
+		return Config{}, fmt.Errorf("invalid WORKER_QUEUE_SIZE value: %s", os.Getenv("WORKER_QUEUE_SIZE"))
+	}
+	logLevel, err := logrus.ParseLevel(GetEnv("LOG_LEVEL", logrus.InfoLevel.String()))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid LOG_LEVEL value: %s", os.Getenv("LOG_LEVEL"))
+	}
+	logFormat := strings.ToLower(GetEnv("LOG_FORMAT", LogFormatText))
+	if logFormat != LogFormatText && logFormat != LogFormatJSON {
+		return Config{}, fmt.Errorf("invalid LOG_FORMAT value: %s", os.Getenv("LOG_FORMAT"))
+	}
+	trustedProxies, err := TrustedProxies(GetList("TRUSTED_PROXIES", ""))
+	if err != nil {
+		return Config{}, err
+	}
+	disabledRoutes, err := DisabledRoutes(GetList("DISABLED_ROUTES", ""))
+	if err != nil {
+		return Config{}, err
+	}
+	mode := GetEnv("TELEMETRY_MODE", TelemetryNewRelic)
+	if mode != TelemetryNewRelic && mode != TelemetryOTel {
+		return Config{}, fmt.Errorf("invalid TELEMETRY_MODE value: %s", mode)
+	}
+	return Config{
+		Enabled:              enabled,
+		AppName:              GetEnv("NEW_RELIC_APP_NAME", DefaultAppName),
+		License:              GetEnv("NEW_RELIC_LICENSE_KEY", DefaultLicense),
+		Environment:          GetEnv("APP_ENV", DefaultEnvironment),
+		SecondaryLicense:     os.Getenv("NEW_RELIC_SECONDARY_LICENSE_KEY"),
+		Host:                 host,
+		Addr:                 addr,
+		GRPCAddr:             grpcAddr,
+		ShutdownTimeout:      timeout,
+		RequestTimeout:       requestTimeout,
+		SlowRequestThreshold: time.Duration(slowRequestMs) * time.Millisecond,
+		ApdexThreshold:       apdexThreshold,
+		RouteApdex:           routeApdex,
+		ConnectTimeout:       connectTimeout,
+		DatabaseURL:          os.Getenv("DATABASE_URL"),
+		PostgresURL:          os.Getenv("POSTGRES_URL"),
+		RedisURL:             os.Getenv("REDIS_URL"),
+		JWTSecret:            os.Getenv("JWT_SECRET"),
+		RunSelfTest:          runSelfTest,
+		AllowTxnNameOverride: allowTxnNameOverride,
+		DebugRoutes:          debugRoutes,
+		DistributedTracing:   distributedTracing,
+		MaxTxnEvents:         maxTxnEvents,
+		MaxSpanEvents:        maxSpanEvents,
+		HighSecurity:         highSecurity,
+		CustomEvents:         customEvents,
+		AttributesExclude:    GetList("NEW_RELIC_ATTRIBUTES_EXCLUDE", DefaultAttributesExclude),
+		LogLevel:             logLevel,
+		LogFormat:            logFormat,
+		TelemetryMode:        mode,
+		TrustedProxies:       trustedProxies,
+		DisabledRoutes:       disabledRoutes,
+		IgnorePaths:          lower(GetList("NEW_RELIC_IGNORE_PATHS", DefaultIgnorePaths)),
+		ErrorBodyLimit:       errorBodyLimit,
+		RateLimit:            rateLimit,
+		RateBurst:            rateBurst,
+		MaxBodyBytes:         maxBodyBytes,
+		IdempotencyCacheSize: idempotencyCacheSize,
+		IdempotencyTTL:       idempotencyTTL,
+		UploadLimit:          uploadLimit,
+		DownstreamURLs:       GetList("DOWNSTREAM_URLS", DefaultDownstreamURLs),
+		ExternalMaxAttempts:  externalMaxAttempts,
+		Workers:              workers,
+		WorkerQueue:          workerQueue,
+		UploadDir:            GetEnv("UPLOAD_DIR", os.TempDir()),
+	}, nil
+}
+
+//GetEnv returns the value of the environment variable or the fallback when it is unset
+func GetEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+//GetBool parses the environment variable with strconv.ParseBool, returning the fallback when it is unset
+func GetBool(key string, fallback bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value: %s", key, value)
+	}
+	return b, nil
+}
+
+//GetInt parses the environment variable with strconv.Atoi, returning the fallback when it is unset
+func GetInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value: %s", key, value)
+	}
+	return n, nil
+}
+
+//GetFloat parses the environment variable with strconv.ParseFloat, returning the fallback when it is unset
+func GetFloat(key string, fallback float64) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value: %s", key, value)
+	}
+	return f, nil
+}
+
+//GetList splits a comma-separated environment variable, dropping blank entries
+func GetList(key, fallback string) []string {
+	var list []string
+	for _, item := range strings.Split(GetEnv(key, fallback), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+//lower returns a lower-cased copy of list
+func lower(list []string) []string {
+	out := make([]string, len(list))
+	for i, item := range list {
+		out[i] = strings.ToLower(item)
+	}
+	return out
+}
+
+/*
+NewRelicOptions builds the New Relic options from the app name and license
+resolved by Load, which fall back to the defaults above. When no value
+can be resolved the returned options make NewApplication fail with a
+descriptive error instead of a generic agent one.
+
+Options are applied in order, so precedence is:
+ 1. the hard-coded defaults and explicit options at the top of the list
+ 2. ConfigFromEnvironment, which overrides them with any NEW_RELIC_* variable
+    that is set (distributed tracing, log forwarding, high security, ...)
+ 3. the options after it, which must win over the environment
+*/
+func NewRelicOptions(c Config) []newrelic.ConfigOption {
+	return []newrelic.ConfigOption{
+		//App name
+		newrelic.ConfigAppName(c.AppName),
+		//Private Key
+		newrelic.ConfigLicense(c.License),
+		//a disabled agent hands out no-op transactions, for local development without a license
+		newrelic.ConfigEnabled(c.Enabled),
+		newrelic.ConfigDistributedTracerEnabled(c.DistributedTracing),
+		//must match the High Security setting of the account, the agent has no option for it
+		func(cfg *newrelic.Config) { cfg.HighSecurity = c.HighSecurity },
+		newrelic.ConfigCustomInsightsEventsEnabled(c.CustomEvents),
+		//sampling limits, lower them to cut data volume at high throughput
+		func(cfg *newrelic.Config) { cfg.TransactionEvents.MaxSamplesStored = c.MaxTxnEvents },
+		newrelic.ConfigDistributedTracerReservoirLimit(c.MaxSpanEvents),
+		//forward application logs to New Relic Logs
+		newrelic.ConfigAppLogForwardingEnabled(true),
+		//every NEW_RELIC_* variable overrides the options above
+		newrelic.ConfigFromEnvironment(),
+		//the validated NEW_RELIC_HOST, trimmed of the spaces the environment option keeps
+		func(cfg *newrelic.Config) {
+			if c.Host != "" {
+				cfg.Host = c.Host
+			}
+		},
+		//replaces the exclude list so the defaults hold even when the variable is unset
+		func(cfg *newrelic.Config) { cfg.Attributes.Exclude = c.AttributesExclude },
+		Labels(map[string]string{
+			"environment": c.Environment,
+			"team":        os.Getenv("APP_TEAM"),
+			"region":      os.Getenv("APP_REGION"),
+		}),
+		func(cfg *newrelic.Config) {
+			if cfg.Error != nil {
+				return
+			}
+			if cfg.AppName == "" {
+				cfg.Error = errors.New("no app name configured: set NEW_RELIC_APP_NAME")
+			}
+			if cfg.License == "" && cfg.Enabled {
+				cfg.Error = errors.New("no license key configured: set NEW_RELIC_LICENSE_KEY")
+			}
+		},
+	}
+}
+
+/*
+SecondaryOptions are NewRelicOptions reporting to the account of
+SecondaryLicense. The license comes last, after ConfigFromEnvironment has
+applied NEW_RELIC_LICENSE_KEY.
+*/
+func SecondaryOptions(c Config) []newrelic.ConfigOption {
+	return append(NewRelicOptions(c), newrelic.ConfigLicense(c.SecondaryLicense))
+}
+
+//Labels adds the non-empty labels to the entity, keeping any set through NEW_RELIC_LABELS
+func Labels(labels map[string]string) newrelic.ConfigOption {
+	return func(cfg *newrelic.Config) {
+		for key, value := range labels {
+			if value == "" {
+				continue
+			}
+			if cfg.Labels == nil {
+				cfg.Labels = map[string]string{}
+			}
+			cfg.Labels[key] = value
+		}
+	}
+}
+
+//ShutdownTimeout reads NEW_RELIC_SHUTDOWN_TIMEOUT as a duration such as "10s"
+func ShutdownTimeout() (time.Duration, error) {
+	return GetDuration("NEW_RELIC_SHUTDOWN_TIMEOUT", DefaultShutdownTimeout)
+}
+
+//GetDuration parses the environment variable as a positive duration, returning the fallback when it is unset
+func GetDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s value: %s", key, value)
+	}
+	return d, nil
+}
+
+//GetDurationMap parses a comma-separated list of key=duration pairs, such as "/slow=2s,/external=1s"
+func GetDurationMap(key string) (map[string]time.Duration, error) {
+	durations := map[string]time.Duration{}
+	for _, item := range GetList(key, "") {
+		name, value, ok := strings.Cut(item, "=")
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if !ok || strings.TrimSpace(name) == "" || err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s entry: %s", key, item)
+		}
+		durations[strings.TrimSpace(name)] = d
+	}
+	return durations, nil
+}
+
+/*
+DisabledRoutes validates a DISABLED_ROUTES list. An entry is a route path
+such as /users/:id, disabling it for every method, or a method and a path
+such as "PUT /cache/:key", disabling that one; methods are upper-cased.
+*/
+func DisabledRoutes(list []string) ([]string, error) {
+	var routes []string
+	for _, entry := range list {
+		fields := strings.Fields(entry)
+		switch {
+		case len(fields) == 1 && strings.HasPrefix(fields[0], "/"):
+			routes = append(routes, fields[0])
+		case len(fields) == 2 && strings.HasPrefix(fields[1], "/"):
+			routes = append(routes, strings.ToUpper(fields[0])+" "+fields[1])
+		default:
+			return nil, fmt.Errorf("invalid DISABLED_ROUTES entry %q: want a path or a method and a path", entry)
+		}
+	}
+	return routes, nil
+}
+
+/*
+TrustedProxies validates a TRUSTED_PROXIES list of IPs and CIDRs. A lone "*"
+trusts every address and is expanded to both IPv4 and IPv6 catch-all ranges.
+*/
+func TrustedProxies(list []string) ([]string, error) {
+	if len(list) == 1 && list[0] == "*" {
+		return []string{"0.0.0.0/0", "::/0"}, nil
+	}
+	for _, proxy := range list {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry: %s", proxy)
+		}
+	}
+	return list, nil
+}
+
+/*
+CollectorHost validates a NEW_RELIC_HOST value, a bare host name or IP with
+an optional port such as collector.eu01.nr-data.net or proxy.internal:8443.
+The agent adds the scheme and path itself, so values carrying either are
+rejected. An empty value keeps the agent's default collector.
+*/
+func CollectorHost(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	invalid := fmt.Errorf("invalid NEW_RELIC_HOST value %q: want a host name with an optional port, without scheme or path", value)
+	host := value
+	if h, port, err := net.SplitHostPort(value); err == nil {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", invalid
+		}
+		host = h
+	}
+	if net.ParseIP(host) != nil {
+		return value, nil
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", invalid
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return "", invalid
+			}
+		}
+	}
+	return value, nil
+}
+
+//ListenAddr validates the port and returns the address to listen on
+func ListenAddr(port string) (string, error) {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q: must be a number between 1 and 65535", port)
+	}
+	//rebuilt from n so that forms Atoi accepts, such as "+80" or "0080", become ":80"
+	return ":" + strconv.Itoa(n), nil
+}
@@ -0,0 +1,318 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/sirupsen/logrus"
+)
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		port    string
+		want    string
+		wantErr bool
+	}{
+		{"8000", ":8000", false},
+		{"1", ":1", false},
+		{"65535", ":65535", false},
+		{"+80", ":80", false},
+		{"0080", ":80", false},
+		{"0", "", true},
+		{"65536", "", true},
+		{"http", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ListenAddr(tt.port)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ListenAddr(%q) error = %v, wantErr %v", tt.port, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("ListenAddr(%q) = %q, want %q", tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", DefaultShutdownTimeout, false},
+		{"3s", 3 * time.Second, false},
+		{"-1s", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("NEW_RELIC_SHUTDOWN_TIMEOUT", tt.value)
+		got, err := ShutdownTimeout()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ShutdownTimeout(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("ShutdownTimeout(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGetList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", []string{"/ignore"}},
+		{"/healthz", []string{"/healthz"}},
+		{" /a, ,/b ,", []string{"/a", "/b"}},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("TEST_LIST", tt.value)
+		if got := GetList("TEST_LIST", "/ignore"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetList(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+//newRelicConfig applies the options of c to an empty agent configuration
+func newRelicConfig(c Config) newrelic.Config {
+	var cfg newrelic.Config
+	for _, opt := range NewRelicOptions(c) {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+func TestHighSecurity(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		cfg := newRelicConfig(Config{AppName: "test", License: DefaultLicense, HighSecurity: enabled})
+		if cfg.HighSecurity != enabled {
+			t.Errorf("HighSecurity = %v, want %v", cfg.HighSecurity, enabled)
+		}
+	}
+}
+
+func TestAttributesExclude(t *testing.T) {
+	t.Setenv("NEW_RELIC_ATTRIBUTES_EXCLUDE", "")
+	c, err := Load(DefaultPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := newRelicConfig(c)
+	if want := []string{"request.headers.authorization", "request.headers.cookie"}; !reflect.DeepEqual(cfg.Attributes.Exclude, want) {
+		t.Errorf("Attributes.Exclude = %q, want %q", cfg.Attributes.Exclude, want)
+	}
+	if !cfg.CustomInsightsEvents.Enabled {
+		t.Error("CustomInsightsEvents.Enabled = false, want true")
+	}
+
+	t.Setenv("NEW_RELIC_ATTRIBUTES_EXCLUDE", "user.email, request.headers.*")
+	if c, err = Load(DefaultPort); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"user.email", "request.headers.*"}; !reflect.DeepEqual(newRelicConfig(c).Attributes.Exclude, want) {
+		t.Errorf("Attributes.Exclude = %q, want %q", newRelicConfig(c).Attributes.Exclude, want)
+	}
+}
+
+func TestEventLimits(t *testing.T) {
+	t.Setenv("NEW_RELIC_MAX_TXN_EVENTS", "500")
+	t.Setenv("NEW_RELIC_MAX_SPAN_EVENTS", "")
+	c, err := Load(DefaultPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := newRelicConfig(c)
+	if cfg.TransactionEvents.MaxSamplesStored != 500 {
+		t.Errorf("TransactionEvents.MaxSamplesStored = %d, want 500", cfg.TransactionEvents.MaxSamplesStored)
+	}
+	if cfg.DistributedTracer.ReservoirLimit != DefaultMaxSpanEvents {
+		t.Errorf("DistributedTracer.ReservoirLimit = %d, want %d", cfg.DistributedTracer.ReservoirLimit, DefaultMaxSpanEvents)
+	}
+
+	t.Setenv("NEW_RELIC_MAX_TXN_EVENTS", "-1")
+	if _, err := Load(DefaultPort); err == nil {
+		t.Error("Load() with a negative NEW_RELIC_MAX_TXN_EVENTS succeeded, want an error")
+	}
+}
+
+func TestSecondaryOptions(t *testing.T) {
+	t.Setenv("NEW_RELIC_LICENSE_KEY", "primary")
+	c := Config{AppName: "test", License: "primary", SecondaryLicense: "secondary"}
+	var cfg newrelic.Config
+	for _, opt := range SecondaryOptions(c) {
+		opt(&cfg)
+	}
+	if cfg.License != "secondary" {
+		t.Errorf("License = %q, want %q", cfg.License, "secondary")
+	}
+	if got := newRelicConfig(c).License; got != "primary" {
+		t.Errorf("primary License = %q, want %q", got, "primary")
+	}
+}
+
+func TestLogSettings(t *testing.T) {
+	tests := []struct {
+		level, format string
+		wantLevel     logrus.Level
+		wantFormat    string
+		wantErr       bool
+	}{
+		{"", "", logrus.InfoLevel, LogFormatText, false},
+		{"debug", "JSON", logrus.DebugLevel, LogFormatJSON, false},
+		{"loud", "", 0, "", true},
+		{"", "xml", 0, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("LOG_LEVEL", tt.level)
+		t.Setenv("LOG_FORMAT", tt.format)
+		c, err := Load(DefaultPort)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Load() with LOG_LEVEL=%q LOG_FORMAT=%q error = %v, wantErr %v", tt.level, tt.format, err, tt.wantErr)
+		}
+		if err == nil && (c.LogLevel != tt.wantLevel || c.LogFormat != tt.wantFormat) {
+			t.Errorf("Load() with LOG_LEVEL=%q LOG_FORMAT=%q = %v, %q, want %v, %q", tt.level, tt.format, c.LogLevel, c.LogFormat, tt.wantLevel, tt.wantFormat)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	t.Setenv("NEW_RELIC_ENABLED", "false")
+	t.Setenv("NEW_RELIC_LICENSE_KEY", "")
+	c, err := Load(DefaultPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.License = ""
+	cfg := newRelicConfig(c)
+	if cfg.Enabled {
+		t.Error("Enabled = true, want false")
+	}
+	if cfg.Error != nil {
+		t.Errorf("Error = %v, want a disabled agent to need no license", cfg.Error)
+	}
+
+	app, err := newrelic.NewApplication(NewRelicOptions(c)...)
+	if err != nil {
+		t.Fatalf("NewApplication() error = %v, want a no-op application", err)
+	}
+	if err := app.WaitForConnection(time.Second); err != nil {
+		t.Errorf("WaitForConnection() error = %v, want it to return at once", err)
+	}
+}
+
+func TestGetDurationMap(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{"", map[string]time.Duration{}, false},
+		{"/slow=2s, /external = 1s", map[string]time.Duration{"/slow": 2 * time.Second, "/external": time.Second}, false},
+		{"/slow", nil, true},
+		{"/slow=soon", nil, true},
+		{"=1s", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("TEST_DURATIONS", tt.value)
+		got, err := GetDurationMap("TEST_DURATIONS")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("GetDurationMap(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetDurationMap(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	tests := []struct {
+		list    []string
+		want    []string
+		wantErr bool
+	}{
+		{nil, nil, false},
+		{[]string{"*"}, []string{"0.0.0.0/0", "::/0"}, false},
+		{[]string{"10.0.0.0/8", "192.168.1.10", "::1"}, []string{"10.0.0.0/8", "192.168.1.10", "::1"}, false},
+		{[]string{"10.0.0.0/33"}, nil, true},
+		{[]string{"lb.internal"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := TrustedProxies(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("TrustedProxies(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TrustedProxies(%q) = %q, want %q", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestCollectorHost(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{" collector.eu01.nr-data.net ", "collector.eu01.nr-data.net", false},
+		{"proxy.internal:8443", "proxy.internal:8443", false},
+		{"10.0.0.5", "10.0.0.5", false},
+		{"[::1]:8443", "[::1]:8443", false},
+		{"https://collector.newrelic.com", "", true},
+		{"collector.newrelic.com/agent_listener", "", true},
+		{"proxy.internal:0", "", true},
+		{"-bad.example", "", true},
+		{"a..b", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := CollectorHost(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CollectorHost(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("CollectorHost(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	t.Setenv("NEW_RELIC_HOST", " collector.eu01.nr-data.net")
+	c, err := Load(DefaultPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := newRelicConfig(c).Host; got != "collector.eu01.nr-data.net" {
+		t.Errorf("Host = %q, want %q", got, "collector.eu01.nr-data.net")
+	}
+}
+
+func TestDisabledRoutes(t *testing.T) {
+	tests := []struct {
+		list    []string
+		want    []string
+		wantErr bool
+	}{
+		{nil, nil, false},
+		{[]string{"/panic", "put /cache/:key", " GET  /users/:id "}, []string{"/panic", "PUT /cache/:key", "GET /users/:id"}, false},
+		{[]string{"panic"}, nil, true},
+		{[]string{"GET"}, nil, true},
+		{[]string{"GET /a /b"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := DisabledRoutes(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("DisabledRoutes(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("DisabledRoutes(%q) = %q, want %q", tt.list, got, tt.want)
+		}
+	}
+}
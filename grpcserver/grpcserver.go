@@ -0,0 +1,92 @@
+package grpcserver
+
+import (
+	"context"
+	"time"
+
+	nrgrpc "github.com/newrelic/go-agent/v3/integrations/nrgrpc"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+/*
+EchoServer is the example gRPC service. It is registered with a hand-written
+service description using the well-known StringValue message, so no protoc
+generated code is needed.
+*/
+type EchoServer interface {
+	Echo(context.Context, *wrapperspb.StringValue) (*wrapperspb.StringValue, error)
+}
+
+//echoServer answers every Echo call with the message it received
+type echoServer struct{}
+
+func (echoServer) Echo(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	//the interceptor put the RPC transaction on the context
+	defer newrelic.FromContext(ctx).StartSegment("echo").End()
+	return wrapperspb.String(in.GetValue()), nil
+}
+
+func echoHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(wrapperspb.StringValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoServer).Echo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: EchoMethod}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoServer).Echo(ctx, req.(*wrapperspb.StringValue))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+//EchoMethod is the full gRPC method name of Echo
+const EchoMethod = "/poc.Echo/Echo"
+
+//echoServiceDesc describes the poc.Echo service for grpc.Server.RegisterService
+var echoServiceDesc = grpc.ServiceDesc{
+	ServiceName: "poc.Echo",
+	HandlerType: (*EchoServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Echo", Handler: echoHandler},
+	},
+}
+
+/*
+New returns a gRPC server with the Echo service registered. The nrgrpc
+interceptors start a transaction for every unary and streaming RPC, named
+after the full method.
+*/
+func New(app *newrelic.Application) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(nrgrpc.UnaryServerInterceptor(app)),
+		grpc.StreamInterceptor(nrgrpc.StreamServerInterceptor(app)),
+	)
+	server.RegisterService(&echoServiceDesc, echoServer{})
+	return server
+}
+
+/*
+Stop stops server gracefully, waiting up to timeout for in-flight RPCs to
+finish. When they are still running at the timeout, it closes every
+connection with Stop instead and returns false, so a hanging RPC cannot block
+the shutdown and the final harvest after it.
+*/
+func Stop(server *grpc.Server, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		server.Stop()
+		<-done
+		return false
+	}
+}
@@ -0,0 +1,85 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestEcho(t *testing.T) {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("test"),
+		newrelic.ConfigEnabled(false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := New(app)
+	go server.Serve(lis)
+	defer server.GracefulStop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	out := new(wrapperspb.StringValue)
+	if err := conn.Invoke(context.Background(), EchoMethod, wrapperspb.String("hello"), out); err != nil {
+		t.Fatal(err)
+	}
+	if out.GetValue() != "hello" {
+		t.Errorf("Echo(%q) = %q, want %q", "hello", out.GetValue(), "hello")
+	}
+}
+
+//hangServer blocks every Echo call until the RPC is cancelled
+type hangServer struct{ started chan struct{} }
+
+func (s hangServer) Echo(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	close(s.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestStop(t *testing.T) {
+	server := grpc.NewServer()
+	if !Stop(server, time.Second) {
+		t.Error("Stop of an idle server = false, want a graceful stop")
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hang := hangServer{started: make(chan struct{})}
+	server = grpc.NewServer()
+	server.RegisterService(&echoServiceDesc, hang)
+	go server.Serve(lis)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go conn.Invoke(context.Background(), EchoMethod, wrapperspb.String("hello"), new(wrapperspb.StringValue))
+	<-hang.started
+
+	start := time.Now()
+	if Stop(server, 50*time.Millisecond) {
+		t.Error("Stop with a hanging RPC = true, want the timeout to force it")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Stop took %v, want it to give up after the timeout", elapsed)
+	}
+}
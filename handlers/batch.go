@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//batchItem is one element of the JSON array accepted by /batch
+type batchItem struct {
+	ID       string `json:"id"`
+	Quantity int    `json:"quantity"`
+}
+
+//batchResult reports how a single batch item was processed
+type batchResult struct {
+	ID    string `json:"id"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+//processItem validates and handles a single batch item
+func processItem(item batchItem) error {
+	if item.ID == "" {
+		return fmt.Errorf("item has no id")
+	}
+	if item.Quantity <= 0 {
+		return fmt.Errorf("item %s: quantity must be positive, got %d", item.ID, item.Quantity)
+	}
+	return nil
+}
+
+/*
+Batch processes every item of the body in its own process-item segment. A
+failing item is noticed as an error but does not stop the rest, so one
+transaction can carry several errors. Custom/BatchSize and
+Custom/BatchFailures summarize the batch.
+*/
+func (h *Handler) Batch(c *gin.Context) {
+	h.logTransaction(c, "processing a batch")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	var items []batchItem
+	if err := c.ShouldBindJSON(&items); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	results := make([]batchResult, 0, len(items))
+	failures := 0
+	for i, item := range items {
+		seg := newrelic.StartSegment(txn, "process-item")
+		err := processItem(item)
+		seg.End()
+
+		if err != nil {
+			failures++
+			noticeErrorWithRequest(txn, c, newrelic.Error{
+				Message:    err.Error(),
+				Class:      "BatchItemError",
+				Attributes: map[string]interface{}{"batch.index": i},
+			})
+			results = append(results, batchResult{ID: item.ID, Error: err.Error()})
+			continue
+		}
+		results = append(results, batchResult{ID: item.ID, OK: true})
+	}
+
+	app := txn.Application()
+	recordCustom(app, "BatchSize", float64(len(items)))
+	recordCustom(app, "BatchFailures", float64(failures))
+	c.JSON(http.StatusOK, results)
+}
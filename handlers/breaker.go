@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/sony/gobreaker"
+)
+
+//failures in a row after which the external breaker opens, and how long it stays open
+const (
+	breakerTrip    = 3
+	breakerTimeout = 30 * time.Second
+)
+
+//breakerStates maps breaker states to the values of the Custom/CircuitBreaker metrics
+var breakerStates = map[gobreaker.State]float64{
+	gobreaker.StateClosed:   0,
+	gobreaker.StateHalfOpen: 1,
+	gobreaker.StateOpen:     2,
+}
+
+/*
+newBreaker returns the circuit breaker guarding the external dependency
+called name. Every state transition is recorded as a circuit_breaker custom
+event through recordEvent, so it reaches every account reported to, and the
+new state as the Custom/CircuitBreaker/<name> metric of app.
+*/
+func newBreaker(app *newrelic.Application, recordEvent func(string, map[string]interface{}), name string) *gobreaker.CircuitBreaker {
+	return gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:    name,
+		Timeout: breakerTimeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= breakerTrip
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			if nil == app {
+				return
+			}
+			recordEvent("circuit_breaker", map[string]interface{}{
+				"name": name,
+				"from": from.String(),
+				"to":   to.String(),
+			})
+			recordCustom(app, "CircuitBreaker/"+name, breakerStates[to])
+		},
+	})
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+GetCache reads a key with the request context, so the nrredis hook records
+the GET as a datastore segment on the active transaction.
+*/
+func (h *Handler) GetCache(c *gin.Context) {
+	h.logTransaction(c, "reading from the cache")
+	key := c.Param("key")
+	value, err := h.Redis.Get(c.Request.Context(), key).Result()
+	if errors.Is(err, redis.Nil) {
+		//a miss is an answer, not a failure of the service
+		respondExpectedError(c, http.StatusNotFound, "NotFound", fmt.Sprintf("key %q not found", key))
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "CacheError", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key": key, "value": value})
+}
+
+//SetCache stores the request body under the key, traced like GetCache
+func (h *Handler) SetCache(c *gin.Context) {
+	h.logTransaction(c, "writing to the cache")
+	key := c.Param("key")
+	value, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "ValidationError", err.Error())
+		return
+	}
+	if err := h.Redis.Set(c.Request.Context(), key, value, 0).Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, "CacheError", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key": key, "value": string(value)})
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//chainKey holds the attributes the /chain layers added, in order
+const chainKey = "chain.attributes"
+
+//addChainAttribute adds key=value to the transaction and remembers the key for Chain's summary
+func addChainAttribute(c *gin.Context, key, value string) {
+	newrelic.FromContext(c.Request.Context()).AddAttribute(key, value)
+	c.Set(chainKey, append(c.GetStringSlice(chainKey), key))
+}
+
+/*
+ChainLayer is one of the middlewares in front of /chain. It adds a
+chain.<name> attribute and wraps the rest of the chain in a chain/<name>
+segment, so the trace shows the layers nested in the order they were
+registered with the handler innermost. nrgin started the one transaction
+for the request before any of them ran, so all of them add to it.
+*/
+func ChainLayer(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		seg := newrelic.FromContext(c.Request.Context()).StartSegment("chain/" + name)
+		defer seg.End()
+		addChainAttribute(c, "chain."+name, "ran at "+time.Now().UTC().Format(time.RFC3339Nano))
+		time.Sleep(2 * time.Millisecond)
+		c.Next()
+	}
+}
+
+//Chain adds its own attribute and lists every attribute the layers before it set
+func (h *Handler) Chain(c *gin.Context) {
+	h.logTransaction(c, "end of the middleware chain")
+	addChainAttribute(c, "chain.handler", "ran at "+time.Now().UTC().Format(time.RFC3339Nano))
+	c.JSON(http.StatusOK, gin.H{
+		"transaction": newrelic.FromContext(c.Request.Context()).Name(),
+		"attributes":  c.GetStringSlice(chainKey),
+	})
+}
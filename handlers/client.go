@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//sensitiveHeaders are removed from the request the instrumentation sees
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+//secretsKey carries the redacted header values from redactTransport to restoreTransport
+type secretsKey struct{}
+
+/*
+redactTransport hands next a copy of the request without the sensitive
+headers, so the external segment started by newrelic.NewRoundTripper never
+records them. The values travel on the request context to restoreTransport,
+which puts them back just before the request goes on the wire.
+*/
+type redactTransport struct {
+	next http.RoundTripper
+}
+
+func (t redactTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	secrets := http.Header{}
+	redacted := req.Clone(req.Context())
+	for _, key := range sensitiveHeaders {
+		if values := redacted.Header.Values(key); len(values) > 0 {
+			secrets[key] = values
+			redacted.Header.Del(key)
+		}
+	}
+	redacted = redacted.WithContext(context.WithValue(req.Context(), secretsKey{}, secrets))
+	return t.next.RoundTrip(redacted)
+}
+
+//restoreTransport sends the request with the headers redactTransport removed
+type restoreTransport struct {
+	base http.RoundTripper
+}
+
+func (t restoreTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if secrets, _ := req.Context().Value(secretsKey{}).(http.Header); len(secrets) > 0 {
+		req = req.Clone(req.Context())
+		for key, values := range secrets {
+			req.Header[key] = values
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+/*
+NewSafeClient returns an HTTP client whose requests are recorded as external
+segments and carry distributed trace headers, while credentials such as the
+Authorization header are kept out of the instrumentation.
+*/
+func NewSafeClient() *http.Client {
+	return &http.Client{
+		Transport: redactTransport{next: newrelic.NewRoundTripper(restoreTransport{base: http.DefaultTransport})},
+	}
+}
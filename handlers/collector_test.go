@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	nrgin "github.com/newrelic/go-agent/v3/integrations/nrgin"
+	"github.com/sirupsen/logrus"
+
+	"NewRelics-POC/internal/nrtest"
+)
+
+//newCollectorHandler returns a Handler whose connected application reports to an nrtest.Collector
+func newCollectorHandler(t *testing.T) (*Handler, *nrtest.Collector) {
+	t.Helper()
+	app, c := nrtest.NewApplication(t, "test")
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return New(app, logger, Deps{}), c
+}
+
+func TestShutdownFlushes(t *testing.T) {
+	h, c := newCollectorHandler(t)
+	serve(h, "GET", "/custom_event", "", h.CustomEvent)
+	if sent := c.Sent("custom_event_data"); len(sent) != 0 {
+		t.Fatalf("custom events were sent before Shutdown: %s", sent)
+	}
+
+	h.App.Shutdown(5 * time.Second)
+	events := c.Payload("custom_event_data")
+	if !bytes.Contains(events, []byte(`"type":"my_event_type"`)) {
+		t.Errorf("the custom event was not flushed by Shutdown: %s", events)
+	}
+}
+
+func TestSegmentsRecorded(t *testing.T) {
+	h, c := newCollectorHandler(t)
+	serve(h, "GET", "/segments", "", h.Segments)
+	h.App.Shutdown(5 * time.Second)
+
+	names := c.MetricNames()
+	for _, want := range []string{"WebTransaction/Go/GET /segments", "Custom/f1", "Custom/f2"} {
+		if !names[want] {
+			t.Errorf("metric %q was not recorded, got %v", want, names)
+		}
+	}
+}
+
+func TestSegmentsTree(t *testing.T) {
+	h, c := newCollectorHandler(t)
+	serve(h, "GET", "/segments_tree?depth=7", "", h.SegmentsTree)
+	h.App.Shutdown(5 * time.Second)
+
+	var levels int
+	for name := range c.MetricNames() {
+		if strings.HasPrefix(name, "Custom/level-") {
+			levels++
+		}
+	}
+	if levels != 7 {
+		t.Errorf("got %d level segment metrics, want 7", levels)
+	}
+	if names := c.MetricNames(); !names["Custom/level-6"] || names["Custom/level-7"] {
+		t.Error("the segments are not named level-0 to level-6")
+	}
+}
+
+func TestLinkedAsync(t *testing.T) {
+	h, _ := newCollectorHandler(t)
+	defer h.App.Shutdown(time.Second)
+
+	rec := serve(h, "GET", "/linked_async", "", h.LinkedAsync)
+	var body struct {
+		TraceID       string `json:"trace_id"`
+		WorkerTraceID string `json:"worker_trace_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.TraceID == "" || body.WorkerTraceID != body.TraceID {
+		t.Errorf("worker trace id = %q, want it to join trace %q", body.WorkerTraceID, body.TraceID)
+	}
+}
+
+func TestPingDownstream(t *testing.T) {
+	var mu sync.Mutex
+	var traceparents []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		mu.Unlock()
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	h, _ := newCollectorHandler(t)
+	defer h.App.Shutdown(time.Second)
+	h.Config.DownstreamURLs = []string{upstream.URL + "/a", upstream.URL + "/b", upstream.URL + "/missing"}
+
+	rec := serve(h, "GET", "/ping-downstream", "", h.PingDownstream)
+	var body struct {
+		Calls []downstreamCall `json:"calls"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Calls) != 3 || body.Calls[0].Status != http.StatusOK || body.Calls[2].Status != http.StatusNotFound || body.Calls[2].Error == "" {
+		t.Errorf("calls = %+v, want two successes and a 404", body.Calls)
+	}
+	for i, tp := range traceparents {
+		if tp == "" {
+			t.Errorf("call %d carried no traceparent header", i)
+		}
+	}
+}
+
+func TestCategorizedSpans(t *testing.T) {
+	h, c := newCollectorHandler(t)
+	serve(h, "GET", "/categorized", "", h.Categorized)
+	h.App.Shutdown(5 * time.Second)
+
+	spans := c.Payload("span_event_data")
+	for _, category := range []string{"compute", "io", "serialization"} {
+		if want := `"category":"` + category + `"`; !bytes.Contains(spans, []byte(want)) {
+			t.Errorf("no span carries %s", want)
+		}
+	}
+}
+
+func TestEarlyEnd(t *testing.T) {
+	h, c := newCollectorHandler(t)
+	serve(h, "GET", "/early_end", "", h.EarlyEnd)
+	h.App.Shutdown(5 * time.Second)
+
+	events := c.Payload("analytic_event_data")
+	if !bytes.Contains(events, []byte(`"before_end":true`)) {
+		t.Error("the attribute added before End is missing")
+	}
+	if bytes.Contains(events, []byte(`"after_end"`)) {
+		t.Error("the attribute added after End was reported")
+	}
+	names := c.MetricNames()
+	if !names["Custom/before-end"] {
+		t.Error("the segment started before End is missing")
+	}
+	if names["Custom/after-end"] {
+		t.Error("the segment started after End was reported")
+	}
+	if len(c.Sent("error_data")) != 0 {
+		t.Error("the error noticed after End was reported")
+	}
+}
+
+func TestLog(t *testing.T) {
+	h, c := newCollectorHandler(t)
+	serve(h, "GET", "/log?message=card+declined&severity=warn&order.id=42", "", h.Log)
+	h.App.Shutdown(5 * time.Second)
+
+	logs := c.Payload("log_event_data")
+	for _, want := range []string{`"level":"WARN"`, `"message":"card declined"`, `"order.id":"42"`, `"trace.id":"`} {
+		if !bytes.Contains(logs, []byte(want)) {
+			t.Errorf("the log event lacks %s: %s", want, logs)
+		}
+	}
+}
+
+func TestComposite(t *testing.T) {
+	h, c := newCollectorHandler(t)
+	gin.SetMode(gin.TestMode)
+	self := gin.New()
+	self.Use(nrgin.Middleware(h.App))
+	self.GET("/segments", h.Segments)
+	self.GET("/custom_event", h.CustomEvent)
+	server := httptest.NewServer(self)
+	defer server.Close()
+	h.Config.Addr = strings.TrimPrefix(server.URL, "http://")
+
+	rec := serve(h, "GET", "/composite", "", h.Composite)
+	var body struct {
+		Calls []compositeCall `json:"calls"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Calls) != 2 || body.Calls[0].Body != "segments!" || body.Calls[1].Status != http.StatusOK {
+		t.Errorf("calls = %+v, want both sub-requests answered", body.Calls)
+	}
+	h.App.Shutdown(5 * time.Second)
+
+	names := c.MetricNames()
+	for _, want := range []string{"External/" + h.Config.Addr + "/all", "WebTransaction/Go/GET /segments", "DurationByCaller/App/1/2/HTTP/all"} {
+		if !names[want] {
+			t.Errorf("metric %s is missing", want)
+		}
+	}
+}
+
+func TestSelfURL(t *testing.T) {
+	for addr, want := range map[string]string{
+		":8000":          "http://localhost:8000",
+		"127.0.0.1:8000": "http://127.0.0.1:8000",
+		"[::1]:8000":     "http://[::1]:8000",
+	} {
+		if got := selfURL(addr); got != want {
+			t.Errorf("selfURL(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestManual(t *testing.T) {
+	h, c := newCollectorHandler(t)
+	rec := httptest.NewRecorder()
+	h.Manual(rec, httptest.NewRequest("GET", "/manual", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "manually instrumented" {
+		t.Errorf("GET /manual = %d %q, want %d %q", rec.Code, rec.Body.String(), http.StatusOK, "manually instrumented")
+	}
+	h.App.Shutdown(5 * time.Second)
+
+	names := c.MetricNames()
+	for _, want := range []string{"WebTransaction/Go/GET /manual", "HttpDispatcher", "Custom/manual-work"} {
+		if !names[want] {
+			t.Errorf("metric %s is missing", want)
+		}
+	}
+	events := c.Payload("analytic_event_data")
+	if want := `"http.statusCode":200`; !bytes.Contains(events, []byte(want)) {
+		t.Errorf("the transaction event lacks %s: %s", want, events)
+	}
+}
+
+func TestChain(t *testing.T) {
+	h, c := newCollectorHandler(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(nrgin.Middleware(h.App), transactionContext())
+	router.GET("/chain", ChainLayer("auth"), ChainLayer("tenant"), ChainLayer("audit"), h.Chain)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/chain", nil))
+	if want := `"attributes":["chain.auth","chain.tenant","chain.audit","chain.handler"]`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("body = %q, want it to contain %q", rec.Body.String(), want)
+	}
+	h.App.Shutdown(5 * time.Second)
+
+	events := c.Payload("analytic_event_data")
+	names := c.MetricNames()
+	for _, layer := range []string{"auth", "tenant", "audit"} {
+		if want := `"chain.` + layer + `":"ran at `; !bytes.Contains(events, []byte(want)) {
+			t.Errorf("the transaction lacks the chain.%s attribute", layer)
+		}
+		if !names["Custom/chain/"+layer] {
+			t.Errorf("the chain/%s segment is missing", layer)
+		}
+	}
+	if !bytes.Contains(events, []byte(`"chain.handler":"ran at `)) {
+		t.Error("the transaction lacks the chain.handler attribute")
+	}
+}
+
+func TestExternalManual(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/defunkt":
+			io.WriteString(w, `{"login":"defunkt"}`)
+		case "/failing/users/defunkt":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name       string
+		base       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"ok", upstream.URL, http.StatusOK, `{"login":"defunkt"}`},
+		{"upstream 404", upstream.URL + "/missing", http.StatusNotFound, `"class":"ExternalStatus"`},
+		{"upstream 500", upstream.URL + "/failing", http.StatusBadGateway, `"class":"ExternalStatus"`},
+	}
+	for _, tt := range tests {
+		h, c := newCollectorHandler(t)
+		h.GitHub.BaseURL = tt.base
+		rec := serve(h, "GET", "/external_manual", "", h.ExternalManual)
+		h.App.Shutdown(5 * time.Second)
+		if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantBody) {
+			t.Errorf("%s: got %d %q, want %d containing %q", tt.name, rec.Code, rec.Body.String(), tt.wantStatus, tt.wantBody)
+		}
+		noticed := bytes.Contains(c.Payload("error_event_data"), []byte(`"error.class":"ExternalStatus"`))
+		if want := tt.wantStatus != http.StatusOK; noticed != want {
+			t.Errorf("%s: ExternalStatus noticed = %v, want %v", tt.name, noticed, want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//compositeParts are the endpoints of this service /composite calls in turn
+var compositeParts = []string{"/segments", "/custom_event"}
+
+//compositeCall is the outcome of one sub-request made by /composite
+type compositeCall struct {
+	Path       string  `json:"path"`
+	Status     int     `json:"status,omitempty"`
+	Body       string  `json:"body,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+//selfURL is the base URL this service answers on, localhost when addr has no host
+func selfURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+/*
+Composite calls other endpoints of this same service through h.Client and
+answers with their aggregated results. The client adds the distributed trace
+headers, so each sub-request is its own transaction that joins this one's
+trace, and the trace waterfall shows the whole composite operation across
+the three transactions of the one service.
+*/
+func (h *Handler) Composite(c *gin.Context) {
+	h.logTransaction(c, "calling own endpoints")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	base := selfURL(h.Config.Addr)
+	calls := make([]compositeCall, 0, len(compositeParts))
+	for _, path := range compositeParts {
+		calls = append(calls, h.callSelf(c, txn, base, path))
+	}
+	c.JSON(http.StatusOK, gin.H{"calls": calls})
+}
+
+//callSelf GETs path on base with the instrumented client, noticing any failure
+func (h *Handler) callSelf(c *gin.Context, txn *newrelic.Transaction, base, path string) compositeCall {
+	call := compositeCall{Path: path}
+	req, err := http.NewRequestWithContext(c.Request.Context(), "GET", base+path, nil)
+	if err != nil {
+		call.Error = err.Error()
+		return call
+	}
+
+	start := time.Now()
+	resp, err := h.Client.Do(req)
+	call.DurationMs = float64(time.Since(start)) / float64(time.Millisecond)
+	if failure, failed := classifyHTTPError(err, resp); failed {
+		noticeErrorWithRequest(txn, c, failure)
+		call.Error = failure.Message
+	}
+	if resp != nil {
+		defer resp.Body.Close()
+		call.Status = resp.StatusCode
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		call.Body = string(body)
+	}
+	return call
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//user is a row of the users table
+type user struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+/*
+DBUsers runs a query with the request context, so nrmysql records it as a
+datastore segment on the active transaction.
+*/
+func (h *Handler) DBUsers(c *gin.Context) {
+	h.logTransaction(c, "querying users")
+	rows, err := h.DB.QueryContext(c.Request.Context(), "SELECT id, name FROM users LIMIT 10")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "DatabaseError", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	users := []user{}
+	for rows.Next() {
+		var u user
+		if err := rows.Scan(&u.ID, &u.Name); err != nil {
+			respondError(c, http.StatusInternalServerError, "DatabaseError", err.Error())
+			return
+		}
+		users = append(users, u)
+	}
+	//an error while iterating ends rows.Next early, the list would be silently cut short
+	if err := rows.Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, "DatabaseError", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
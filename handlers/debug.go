@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//Trace returns the distributed trace headers an outbound call from this transaction would carry
+func (h *Handler) Trace(c *gin.Context) {
+	h.logTransaction(c, "echoing trace headers")
+	hdrs := http.Header{}
+	if txn := newrelic.FromContext(c.Request.Context()); txn != nil {
+		txn.InsertDistributedTraceHeaders(hdrs)
+	}
+
+	out := map[string]string{}
+	for _, key := range []string{"traceparent", "tracestate", "newrelic"} {
+		out[key] = hdrs.Get(key)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+//maskSecret hides all but the last 4 characters of a secret
+func maskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", len(secret)-4) + secret[len(secret)-4:]
+}
+
+//AgentConfig reports the effective agent configuration, the license key is masked
+func (h *Handler) AgentConfig(c *gin.Context) {
+	h.logTransaction(c, "reporting agent configuration")
+	c.JSON(http.StatusOK, gin.H{
+		"app_name":            h.Config.AppName,
+		"license":             maskSecret(h.Config.License),
+		"distributed_tracing": h.Config.DistributedTracing,
+		"agent_version":       newrelic.Version,
+		"listen_addr":         h.Config.Addr,
+	})
+}
+
+//TraceDump returns the ids needed to find this request in New Relic
+func (h *Handler) TraceDump(c *gin.Context) {
+	h.logTransaction(c, "dumping trace metadata")
+	txn := newrelic.FromContext(c.Request.Context())
+	trace := txn.GetTraceMetadata()
+	linking := txn.GetLinkingMetadata()
+	c.JSON(http.StatusOK, gin.H{
+		"trace_id":    trace.TraceID,
+		"span_id":     trace.SpanID,
+		"entity_guid": linking.EntityGUID,
+		"entity_name": linking.EntityName,
+		"entity_type": linking.EntityType,
+		"hostname":    linking.Hostname,
+	})
+}
+
+//harvestPeriod is the default agent harvest cycle, the collector may shorten it for events
+const harvestPeriod = 60 * time.Second
+
+//nextHarvest estimates the next harvest after now for cycles of period started at start
+func nextHarvest(start, now time.Time, period time.Duration) time.Time {
+	if now.Before(start) {
+		return start.Add(period)
+	}
+	cycles := now.Sub(start)/period + 1
+	return start.Add(cycles * period)
+}
+
+/*
+Flush records a flush_check custom event with a unique id and reports when
+the agent should send it at the latest, so it can be looked up with
+SELECT * FROM flush_check WHERE id = '<id>' once that time has passed. The
+estimate counts harvest cycles from when the Handler was created.
+*/
+func (h *Handler) Flush(c *gin.Context) {
+	h.logTransaction(c, "recording a flush check event")
+	id := uuid.NewString()
+	now := time.Now()
+	h.recordEvent("flush_check", map[string]interface{}{"id": id})
+	c.JSON(http.StatusOK, gin.H{
+		"event_type":   "flush_check",
+		"id":           id,
+		"recorded_at":  now,
+		"next_harvest": nextHarvest(h.started, now, harvestPeriod),
+	})
+}
+
+/*
+buildSettings picks the module path, Go version and VCS details out of the
+build info the toolchain embeds in the binary. The vcs.* settings are only
+there for binaries built from a checkout, go run and go test leave them out.
+*/
+func buildSettings(info *debug.BuildInfo) gin.H {
+	out := gin.H{
+		"module":     info.Main.Path,
+		"version":    info.Main.Version,
+		"go_version": info.GoVersion,
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			out["vcs_revision"] = setting.Value
+		case "vcs.time":
+			out["vcs_time"] = setting.Value
+		case "vcs.modified":
+			out["vcs_modified"] = setting.Value == "true"
+		}
+	}
+	return out
+}
+
+//BuildInfo reports where the running binary came from, without any ldflags
+func (h *Handler) BuildInfo(c *gin.Context) {
+	h.logTransaction(c, "reporting build info")
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		respondError(c, http.StatusNotFound, "BuildInfoUnavailable", "the binary carries no build info")
+		return
+	}
+	c.JSON(http.StatusOK, buildSettings(info))
+}
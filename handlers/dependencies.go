@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//probeTimeout bounds every health check made by /dependencies
+const probeTimeout = 2 * time.Second
+
+//dependency is one downstream service reported by /dependencies
+type dependency struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	URL       string `json:"url"`
+	Reachable bool   `json:"reachable"`
+	Status    int    `json:"status,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+//passwordParam matches a password= value in a URL query or a key/value DSN, quoted or not
+var passwordParam = regexp.MustCompile(`(?i)(\bpassword=)('[^']*'|[^&\s]*)`)
+
+/*
+maskURL hides the passwords of a connection string. URLs such as
+redis://:secret@host use url.Redacted, MySQL DSNs such as
+user:secret@tcp(host)/db have everything between the first ':' and the last
+'@' replaced. A password= value is masked as well, whether it is in the query
+of a URL such as postgres://db/poc?password=secret or in a key/value DSN
+such as host=db password=secret.
+*/
+func maskURL(raw string) string {
+	return passwordParam.ReplaceAllString(maskUserinfo(raw), "${1}xxxxx")
+}
+
+//maskUserinfo hides the password in the user:password@ part of raw
+func maskUserinfo(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Redacted()
+	}
+	at := strings.LastIndex(raw, "@")
+	colon := strings.Index(raw, ":")
+	if at < 0 || colon < 0 || colon > at {
+		return raw
+	}
+	return raw[:colon+1] + "xxxxx" + raw[at:]
+}
+
+//pingDatastore times ping as a datastore segment of the given product
+func pingDatastore(ctx context.Context, product newrelic.DatastoreProduct, ping func(context.Context) error) error {
+	seg := newrelic.DatastoreSegment{
+		StartTime: newrelic.FromContext(ctx).StartSegmentNow(),
+		Product:   product,
+		Operation: "ping",
+	}
+	defer seg.End()
+	return ping(ctx)
+}
+
+//probe runs check and records the outcome on d
+func probe(d dependency, check func() error) dependency {
+	if err := check(); err != nil {
+		d.Error = err.Error()
+	} else {
+		d.Reachable = true
+	}
+	return d
+}
+
+/*
+probeHTTP sends a HEAD request to d.URL and records the status it answered.
+A 5xx means the service is up but failing, so it counts as unreachable like
+no answer at all, while any other status shows it is serving requests.
+*/
+func (h *Handler) probeHTTP(ctx context.Context, d dependency) dependency {
+	var status int
+	d = probe(d, func() error {
+		req, err := http.NewRequestWithContext(ctx, "HEAD", d.URL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := h.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+		if status >= http.StatusInternalServerError {
+			return fmt.Errorf("upstream answered %s", resp.Status)
+		}
+		return nil
+	})
+	d.Status = status
+	return d
+}
+
+/*
+Dependencies lists the downstream services this app talks to with their
+masked URLs, probing each one. The probes are external and datastore
+segments, so the transaction doubles as a dependency check in New Relic.
+Datastores that are not configured are left out.
+*/
+func (h *Handler) Dependencies(c *gin.Context) {
+	h.logTransaction(c, "probing dependencies")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), probeTimeout)
+	defer cancel()
+
+	deps := []dependency{h.probeHTTP(ctx, dependency{Name: "github", Kind: "external", URL: h.GitHub.BaseURL})}
+	if h.DB != nil {
+		deps = append(deps, probe(dependency{Name: "mysql", Kind: "datastore", URL: maskURL(h.Config.DatabaseURL)}, func() error {
+			return pingDatastore(ctx, newrelic.DatastoreMySQL, h.DB.PingContext)
+		}))
+	}
+	if h.PG != nil {
+		deps = append(deps, probe(dependency{Name: "postgres", Kind: "datastore", URL: maskURL(h.Config.PostgresURL)}, func() error {
+			return pingDatastore(ctx, newrelic.DatastorePostgres, h.PG.Ping)
+		}))
+	}
+	if h.Redis != nil {
+		//nrredis already records the command as a datastore segment
+		deps = append(deps, probe(dependency{Name: "redis", Kind: "datastore", URL: maskURL(h.Config.RedisURL)}, func() error {
+			return h.Redis.Ping(ctx).Err()
+		}))
+	}
+	c.JSON(http.StatusOK, deps)
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"NewRelics-POC/jobs"
+)
+
+/*
+Enqueue queues a job on the worker pool and answers 202 right away, the job
+is processed later in its own background transaction. When the queue is full
+the client gets a 503 instead of waiting.
+*/
+func (h *Handler) Enqueue(c *gin.Context) {
+	h.logTransaction(c, "enqueueing a job")
+	job := jobs.Job{ID: c.DefaultQuery("id", uuid.NewString()), Payload: c.Query("payload")}
+	if !h.Pool.Enqueue(job) {
+		respondError(c, http.StatusServiceUnavailable, "QueueFull", "the job queue is full, retry later")
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
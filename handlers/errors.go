@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+/*
+requestError turns err into a newrelic.Error carrying the request URL, method
+and user agent. The class and attributes of an err that already is a
+newrelic.Error are kept, any other error is classed by its type as the agent
+would do.
+*/
+func requestError(c *gin.Context, err error) newrelic.Error {
+	nrErr := newrelic.Error{
+		Message: err.Error(),
+		Class:   reflect.TypeOf(err).String(),
+		Attributes: map[string]interface{}{
+			"request.url":    c.Request.URL.String(),
+			"request.method": c.Request.Method,
+			"user.agent":     c.Request.UserAgent(),
+		},
+	}
+	var e newrelic.Error
+	if errors.As(err, &e) {
+		if e.Class != "" {
+			nrErr.Class = e.Class
+		}
+		for key, value := range e.Attributes {
+			nrErr.Attributes[key] = value
+		}
+	}
+	return nrErr
+}
+
+//noticeErrorWithRequest notices err on txn with the request details attached
+func noticeErrorWithRequest(txn *newrelic.Transaction, c *gin.Context, err error) {
+	txn.NoticeError(requestError(c, err))
+}
+
+/*
+ErrorBody is the {"error":{"class":...,"message":...}} body of every error
+response, shared with the server middleware so rejected requests answer in
+the same shape as failed handlers.
+*/
+func ErrorBody(class, message string) gin.H {
+	return gin.H{"error": gin.H{"class": class, "message": message}}
+}
+
+/*
+respondError is the error contract of the example endpoints. It answers
+{"error":{"class":...,"message":...}} with status and notices the same error
+on the transaction, so every error response can be found in New Relic.
+*/
+func respondError(c *gin.Context, status int, class, message string) {
+	noticeErrorWithRequest(newrelic.FromContext(c.Request.Context()), c, newrelic.Error{
+		Message: message,
+		Class:   class,
+	})
+	c.JSON(status, ErrorBody(class, message))
+}
+
+//respondExpectedError is respondError for failures that should not count toward the error rate
+func respondExpectedError(c *gin.Context, status int, class, message string) {
+	newrelic.FromContext(c.Request.Context()).NoticeExpectedError(requestError(c, newrelic.Error{
+		Message: message,
+		Class:   class,
+	}))
+	c.JSON(status, ErrorBody(class, message))
+}
+
+/*
+respondBindingError answers a request whose body failed to bind or validate
+with the usual ValidationError body. The error is added to c.Errors as a bind
+error instead of being noticed here, the binding middleware notices it as a
+BindingError with the failed fields as attributes.
+*/
+func respondBindingError(c *gin.Context, err error) {
+	c.Error(err).SetType(gin.ErrorTypeBind)
+	c.JSON(http.StatusBadRequest, ErrorBody("ValidationError", err.Error()))
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/sony/gobreaker"
+
+	"NewRelics-POC/service"
+)
+
+//githubAPI is the default base URL of the GitHub API
+const githubAPI = "https://api.github.com"
+
+/*
+External fetches the GitHub profile of ?login=, defunkt by default, with
+h.GitHub behind the circuit breaker and renders it as JSON. The client
+records the call as an external segment, retries included.
+*/
+func (h *Handler) External(c *gin.Context) {
+	h.logTransaction(c, "calling external API")
+	login := c.DefaultQuery("login", "defunkt")
+
+	var user *service.GitHubUser
+	var callErr error
+	_, err := h.Breaker.Execute(func() (interface{}, error) {
+		user, callErr = h.GitHub.GetUser(c.Request.Context(), login)
+		//a 4xx is the caller's problem, only no answer or a 5xx counts against the upstream
+		var status *service.StatusError
+		if errors.As(callErr, &status) && status.StatusCode < http.StatusInternalServerError {
+			return nil, nil
+		}
+		return nil, callErr
+	})
+	recordCustom(h.App, "CircuitBreaker/"+h.Breaker.Name(), breakerStates[h.Breaker.State()])
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		//failing fast is the breaker doing its job, so it is noticed as expected
+		respondExpectedError(c, http.StatusServiceUnavailable, "CircuitOpen", "the upstream is unavailable, retry later")
+		return
+	}
+	if callErr != nil {
+		failure, _ := classifyHTTPError(callErr, nil)
+		respondError(c, externalStatus(failure), failure.Class, failure.Message)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+//error classes of failed external calls, so the error inbox groups them by cause
+const (
+	classExternalTimeout = "ExternalTimeout"
+	classExternalDNS     = "ExternalDNS"
+	classExternalStatus  = "ExternalStatus"
+	classExternalError   = "ExternalError"
+)
+
+/*
+classifyHTTPError sorts the outcome of an outbound call into an error class:
+timeouts, DNS failures, non-2xx responses, given as resp or as a
+service.StatusError, and any other transport error. It returns false when
+the call succeeded.
+*/
+func classifyHTTPError(err error, resp *http.Response) (newrelic.Error, bool) {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var statusErr *service.StatusError
+	switch {
+	case err != nil && errors.As(err, &dnsErr):
+		return newrelic.Error{Message: err.Error(), Class: classExternalDNS}, true
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return newrelic.Error{Message: err.Error(), Class: classExternalTimeout}, true
+	case errors.As(err, &statusErr):
+		return newrelic.Error{
+			Message:    err.Error(),
+			Class:      classExternalStatus,
+			Attributes: map[string]interface{}{"http.statusCode": statusErr.StatusCode},
+		}, true
+	case resp != nil && (resp.StatusCode < 200 || resp.StatusCode > 299):
+		return newrelic.Error{
+			Message:    "upstream answered " + resp.Status,
+			Class:      classExternalStatus,
+			Attributes: map[string]interface{}{"http.statusCode": resp.StatusCode},
+		}, true
+	case err != nil:
+		return newrelic.Error{Message: err.Error(), Class: classExternalError}, true
+	}
+	return newrelic.Error{}, false
+}
+
+//externalStatus is the status answered for a failed external call, upstream 4xx codes are passed on
+func externalStatus(failure newrelic.Error) int {
+	code, _ := failure.Attributes["http.statusCode"].(int)
+	switch {
+	case failure.Class == classExternalTimeout:
+		return http.StatusGatewayTimeout
+	case code >= http.StatusBadRequest && code < http.StatusInternalServerError:
+		return code
+	}
+	return http.StatusBadGateway
+}
+
+/*
+ExternalManual calls the GitHub API of h.GitHub by hand, managing the
+external segment itself. A transport error or a non-2xx answer is noticed
+and classified like /external; otherwise the upstream body is copied back.
+*/
+func (h *Handler) ExternalManual(c *gin.Context) {
+	h.logTransaction(c, "calling external API manually")
+	txn := newrelic.FromContext(c.Request.Context())
+	//built from the request context so the request timeout cancels the call too
+	req, err := http.NewRequestWithContext(c.Request.Context(), "GET", h.GitHub.BaseURL+"/users/defunkt", nil)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, classExternalError, err.Error())
+		return
+	}
+
+	es := newrelic.StartExternalSegment(txn, req)
+	//add the W3C traceparent/tracestate and newrelic headers so the downstream
+	//service can continue the trace, the receiving service calls
+	//txn.AcceptDistributedTraceHeaders(newrelic.TransportHTTP, r.Header) before doing any work
+	txn.InsertDistributedTraceHeaders(req.Header)
+	resp, err := http.DefaultClient.Do(req)
+	es.Response = resp
+	es.End()
+
+	if failure, failed := classifyHTTPError(err, resp); failed {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		respondError(c, externalStatus(failure), failure.Class, failure.Message)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(c.Writer, resp.Body)
+}
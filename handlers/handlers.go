@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	nrgin "github.com/newrelic/go-agent/v3/integrations/nrgin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/sony/gobreaker"
+
+	"NewRelics-POC/config"
+	"NewRelics-POC/jobs"
+	"NewRelics-POC/service"
+	"NewRelics-POC/telemetry"
+	"NewRelics-POC/version"
+)
+
+//Handler holds the dependencies shared by the example endpoints
+type Handler struct {
+	//App is the New Relic application, used for work outside of a request transaction
+	App *newrelic.Application
+	//Secondary is a second application reporting to another account, nil unless NEW_RELIC_SECONDARY_LICENSE_KEY is set
+	Secondary *newrelic.Application
+	//DB is the instrumented MySQL connection, nil when no database is configured
+	DB *sql.DB
+	//PG is the PostgreSQL pool traced by nrpgx5, nil when POSTGRES_URL is not set
+	PG *pgxpool.Pool
+	//Redis is the instrumented Redis client, nil when REDIS_URL is not set
+	Redis *redis.Client
+	//Client records an external segment and adds trace headers for every outbound request, credentials are redacted
+	Client *http.Client
+	//GitHub calls the GitHub API for /external through Client
+	GitHub *service.GitHubClient
+	//Breaker stops calling the /external dependency after repeated failures
+	Breaker *gobreaker.CircuitBreaker
+	//Logger forwards log lines to New Relic Logs
+	Logger *logrus.Logger
+	//Config is the resolved application configuration
+	Config config.Config
+	//Pool runs jobs queued by /enqueue in background transactions
+	Pool *jobs.Pool
+	//Service is the business layer, traced through the request context
+	Service *service.Service
+	//simulator picks the requests /simulate fails
+	simulator *simulator
+	//started is when the Handler was created, close to when the agent began harvesting
+	started time.Time
+}
+
+//Deps are the optional dependencies of a Handler, a nil or zero field leaves its endpoints disabled or at their defaults
+type Deps struct {
+	//Secondary is a second application reporting to another account
+	Secondary *newrelic.Application
+	//DB is the instrumented MySQL connection
+	DB *sql.DB
+	//PG is the PostgreSQL pool traced by nrpgx5
+	PG *pgxpool.Pool
+	//Redis is the instrumented Redis client
+	Redis *redis.Client
+	//Config is the resolved application configuration, ExternalMaxAttempts also bounds the GitHub client's attempts
+	Config config.Config
+	//Pool runs jobs queued by /enqueue
+	Pool *jobs.Pool
+}
+
+//New returns a Handler for deps with an instrumented HTTP client that redacts credentials
+func New(app *newrelic.Application, logger *logrus.Logger, deps Deps) *Handler {
+	client := NewSafeClient()
+	h := &Handler{
+		App:       app,
+		Secondary: deps.Secondary,
+		DB:        deps.DB,
+		PG:        deps.PG,
+		Redis:     deps.Redis,
+		Client:    client,
+		GitHub:    service.NewGitHubClient(githubAPI, client),
+		Logger:    logger,
+		Config:    deps.Config,
+		Pool:      deps.Pool,
+		Service:   service.New(),
+		simulator: newSimulator(time.Now().UnixNano()),
+		started:   time.Now(),
+	}
+	if deps.Config.ExternalMaxAttempts > 0 {
+		h.GitHub.MaxAttempts = deps.Config.ExternalMaxAttempts
+	}
+	h.Breaker = newBreaker(app, h.recordEvent, "external")
+	return h
+}
+
+//RequestIDKey holds the request's X-Request-ID on the gin context
+const RequestIDKey = "request.id"
+
+//UserIDKey holds the id of the calling user on the gin context, unset for anonymous requests
+const UserIDKey = "user.id"
+
+//logTransaction writes an info line carrying the transaction name so logs-in-context links work
+func (h *Handler) logTransaction(c *gin.Context, msg string) {
+	entry := h.Logger.WithContext(c.Request.Context())
+	if txn := newrelic.FromContext(c.Request.Context()); txn != nil {
+		entry = entry.WithField("transaction", txn.Name())
+	}
+	if id := c.GetString(RequestIDKey); id != "" {
+		entry = entry.WithField("request_id", id)
+	}
+	entry.Info(msg)
+}
+
+//recordEvent records a custom event to the primary and, when configured, the secondary application
+func (h *Handler) recordEvent(eventType string, params map[string]interface{}) {
+	telemetry.RecordEvent(eventType, params, h.App, h.Secondary)
+}
+
+/*
+recordCustom records a custom metric under a consistent name. The agent
+prefixes every custom metric with "Custom/", so callers pass the bare name
+and any prefix they add themselves is stripped to avoid "Custom/Custom/...".
+*/
+func recordCustom(app *newrelic.Application, name string, value float64) {
+	if nil == app {
+		return
+	}
+	name = strings.TrimPrefix(strings.TrimSpace(name), "Custom/")
+	app.RecordCustomMetric(name, value)
+}
+
+//tranction example
+func (h *Handler) EndpointAccessTransaction(c *gin.Context) {
+	txn := nrgin.Transaction(c)
+	txn.SetName("test-txn")
+	h.logTransaction(c, "test transaction")
+	c.Writer.WriteString("test Transaction")
+}
+
+func (h *Handler) Index(c *gin.Context) {
+	h.logTransaction(c, "hello world")
+	io.WriteString(c.Writer, "hello world")
+}
+
+//Version reports the agent version and the build metadata set through -ldflags
+func (h *Handler) Version(c *gin.Context) {
+	h.logTransaction(c, "agent version")
+	c.JSON(http.StatusOK, gin.H{
+		"agent_version": newrelic.Version,
+		"version":       version.Version,
+		"git_commit":    version.GitCommit,
+		"build_date":    version.BuildDate,
+	})
+}
+
+//Whoami reports which New Relic entity and host served the request, to match a pod with its entity
+func (h *Handler) Whoami(c *gin.Context) {
+	h.logTransaction(c, "reporting entity and host")
+	md := newrelic.FromContext(c.Request.Context()).GetLinkingMetadata()
+	hostname, _ := os.Hostname()
+	c.JSON(http.StatusOK, gin.H{
+		"entity_name":    md.EntityName,
+		"entity_type":    md.EntityType,
+		"entity_guid":    md.EntityGUID,
+		"agent_hostname": md.Hostname,
+		"hostname":       hostname,
+		"trace_id":       md.TraceID,
+	})
+}
+
+//NoticeError answers with an error response, which notices the error on the transaction
+func (h *Handler) NoticeError(c *gin.Context) {
+	h.logTransaction(c, "noticing an error")
+	respondError(c, http.StatusInternalServerError, "ExampleError", "my error message")
+}
+
+//notice error with attributes
+func (h *Handler) NoticeErrorWithAttributes(c *gin.Context) {
+	h.logTransaction(c, "noticing an error with attributes")
+	io.WriteString(c.Writer, "noticing an error")
+	txn := newrelic.FromContext(c.Request.Context())
+	noticeErrorWithRequest(txn, c, newrelic.Error{
+		Message: "something went very wrong",
+		Class:   "errors are aggregated by class",
+		Attributes: map[string]interface{}{
+			"error no.": 97232,
+		},
+	})
+}
+
+/*
+ErrorVsLog reports one simulated failure both ways to compare them in the UI:
+  - NoticeError makes a TransactionError event: it counts toward the error
+    rate and alerts, is grouped by class in the errors inbox and links to the
+    transaction and its trace
+  - the error log line goes through the nrlogrus formatter installed by main,
+    which forwards it to Logs with the trace.id and span.id of the transaction
+    on the context, so it shows in the trace's logs tab but changes no
+    error metric
+
+Logging alone hides failures from error alerting, noticing alone loses the
+free-form context a log line can carry.
+*/
+func (h *Handler) ErrorVsLog(c *gin.Context) {
+	h.logTransaction(c, "reporting a failure as an error and a log line")
+	err := errors.New("payment provider rejected the card")
+
+	noticeErrorWithRequest(newrelic.FromContext(c.Request.Context()), c, newrelic.Error{
+		Message: err.Error(),
+		Class:   "PaymentDeclined",
+	})
+	h.Logger.WithContext(c.Request.Context()).WithError(err).WithField("order.id", "demo-1").Error("payment failed")
+
+	c.JSON(http.StatusOK, gin.H{"noticed": "PaymentDeclined", "logged": "payment failed"})
+}
+
+/*
+Log records ?message= as a log event of ?severity= (INFO by default) straight
+through the agent, without any logging library. Every other query parameter
+becomes an attribute of the event. Recording it on the transaction links it
+to the trace, so it shows in the Logs tab of the trace with its trace.id and
+span.id.
+*/
+func (h *Handler) Log(c *gin.Context) {
+	h.logTransaction(c, "recording a log event")
+	message := c.Query("message")
+	if message == "" {
+		respondError(c, http.StatusBadRequest, "ValidationError", "message is required")
+		return
+	}
+	severity := strings.ToUpper(c.DefaultQuery("severity", "info"))
+
+	attrs := map[string]any{}
+	for key, values := range c.Request.URL.Query() {
+		if key != "message" && key != "severity" {
+			attrs[key] = values[0]
+		}
+	}
+	newrelic.FromContext(c.Request.Context()).RecordLog(newrelic.LogData{
+		Severity:   severity,
+		Message:    message,
+		Attributes: attrs,
+	})
+	c.JSON(http.StatusOK, gin.H{"severity": severity, "message": message, "attributes": attrs})
+}
+
+/*
+Expected errors are still recorded in error analytics, but they are not
+counted toward the error rate and do not make the transaction frustrating
+for Apdex. Unexpected errors count toward both.
+*/
+func (h *Handler) ExpectedError(c *gin.Context) {
+	h.logTransaction(c, "noticing an expected error")
+	io.WriteString(c.Writer, "noticing an expected error")
+	if txn := newrelic.FromContext(c.Request.Context()); txn != nil {
+		txn.NoticeExpectedError(requestError(c, newrelic.Error{
+			Message: "this error was expected",
+			Class:   "Expected",
+		}))
+	}
+}
+
+func (h *Handler) CustomEvent(c *gin.Context) {
+	h.logTransaction(c, "recording a custom event")
+	io.WriteString(c.Writer, "recording a custom event")
+
+	h.recordEvent("my_event_type", map[string]interface{}{
+		"message": "hello world",
+		"Float":   0.603,
+		"Int":     123,
+		"Bool":    true,
+	})
+}
+
+//maxEventAttributes is the most attributes New Relic keeps on a custom event
+const maxEventAttributes = 64
+
+/*
+DynamicEvent records a dynamic_event custom event built from the query
+string, ?key=value becomes an attribute and numeric values are sent as
+floats. Repeated keys keep their first value.
+*/
+func (h *Handler) DynamicEvent(c *gin.Context) {
+	h.logTransaction(c, "recording a dynamic custom event")
+	query := c.Request.URL.Query()
+	if len(query) > maxEventAttributes {
+		respondError(c, http.StatusBadRequest, "ValidationError",
+			fmt.Sprintf("custom events support at most %d attributes, got %d", maxEventAttributes, len(query)))
+		return
+	}
+
+	attrs := make(map[string]interface{}, len(query))
+	for key, values := range query {
+		//NaN and infinities stay strings, neither JSON nor the event can carry them
+		if f, err := strconv.ParseFloat(values[0], 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			attrs[key] = f
+		} else {
+			attrs[key] = values[0]
+		}
+	}
+	h.recordEvent("dynamic_event", attrs)
+	c.JSON(http.StatusOK, attrs)
+}
+
+func (h *Handler) SetName(c *gin.Context) {
+	io.WriteString(c.Writer, "changing the transaction's name")
+
+	if txn := newrelic.FromContext(c.Request.Context()); txn != nil {
+		txn.SetName("other-name")
+	}
+	h.logTransaction(c, "changing the transaction's name")
+}
+
+func (h *Handler) AddAttribute(c *gin.Context) {
+	h.logTransaction(c, "adding attributes")
+	io.WriteString(c.Writer, "adding attributes")
+
+	if txn := newrelic.FromContext(c.Request.Context()); txn != nil {
+		txn.AddAttribute("myString", "hello")
+		txn.AddAttribute("myInt", 123)
+	}
+}
+
+/*
+EarlyEnd ends its transaction halfway through the handler and then keeps
+using it. Once End has run the transaction is finished: attributes, errors
+and segments added afterwards are dropped, the agent only logs that the
+transaction has already ended, and the later End calls of segments and of the
+nrgin middleware do nothing. Nothing panics, so code that may outlive its
+transaction is safe, but anything worth reporting has to happen before End.
+*/
+func (h *Handler) EarlyEnd(c *gin.Context) {
+	h.logTransaction(c, "ending the transaction early")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	txn.AddAttribute("before_end", true)
+	seg := txn.StartSegment("before-end")
+	time.Sleep(5 * time.Millisecond)
+	seg.End()
+	txn.End()
+
+	//none of these are reported
+	txn.AddAttribute("after_end", true)
+	txn.NoticeError(errors.New("noticed after end"))
+	seg = txn.StartSegment("after-end")
+	time.Sleep(5 * time.Millisecond)
+	seg.End()
+
+	c.JSON(http.StatusOK, gin.H{
+		"ended":   true,
+		"ignored": []string{"AddAttribute", "NoticeError", "StartSegment"},
+	})
+}
+
+//IgnoredKey is set on the gin context when the transaction was ignored by path
+const IgnoredKey = "nr.ignored"
+
+//whether the request is ignored is decided by NEW_RELIC_IGNORE_PATHS, not by the handler
+func (h *Handler) Ignore(c *gin.Context) {
+	h.logTransaction(c, "ignore check")
+	if c.GetBool(IgnoredKey) {
+		io.WriteString(c.Writer, "ignoring the transaction")
+	} else {
+		io.WriteString(c.Writer, "not ignoring the transaction")
+	}
+}
+
+/*
+These custom metrics will have the names "Custom/HeaderLength" and
+"Custom/RequestPayloadSize" in the New Relic UI.
+*/
+func (h *Handler) CustomMetric(c *gin.Context) {
+	h.logTransaction(c, "recording custom metric")
+	txn := newrelic.FromContext(c.Request.Context())
+	app := txn.Application()
+	for _, vals := range c.Request.Header {
+		for _, v := range vals {
+			recordCustom(app, "HeaderLength", float64(len(v)))
+		}
+	}
+	if c.Request.ContentLength >= 0 {
+		recordCustom(app, "RequestPayloadSize", float64(c.Request.ContentLength))
+	}
+	io.WriteString(c.Writer, "custom metric recorded")
+}
+
+//bounds for the number of samples recorded by /timing
+const (
+	defaultTimingSamples = 10
+	maxTimingSamples     = 100
+)
+
+/*
+Timing records ?n= samples of a random 5-50ms sleep as Custom/SyntheticDuration.
+The agent does not send each call, it aggregates every value recorded for a
+metric name within a harvest into a count, total, min, max and sum of
+squares, so New Relic charts the average and range rather than single
+points. The response carries the same summary computed locally.
+*/
+func (h *Handler) Timing(c *gin.Context) {
+	h.logTransaction(c, "recording timing samples")
+	app := newrelic.FromContext(c.Request.Context()).Application()
+
+	n := defaultTimingSamples
+	if value := c.Query("n"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxTimingSamples {
+			respondError(c, http.StatusBadRequest, "ValidationError", fmt.Sprintf("n must be between 1 and %d", maxTimingSamples))
+			return
+		}
+		n = parsed
+	}
+
+	var total, minMs, maxMs float64
+	for i := 0; i < n; i++ {
+		start := time.Now()
+		time.Sleep(time.Duration(5+rand.Intn(46)) * time.Millisecond)
+		ms := float64(time.Since(start)) / float64(time.Millisecond)
+		recordCustom(app, "SyntheticDuration", ms)
+
+		total += ms
+		if i == 0 || ms < minMs {
+			minMs = ms
+		}
+		if ms > maxMs {
+			maxMs = ms
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"count": n, "min_ms": minMs, "max_ms": maxMs, "avg_ms": total / float64(n)})
+}
+
+/*
+BrowserTimingHeader() will always return a header whose methods can
+be safely called.
+*/
+func (h *Handler) Browser(c *gin.Context) {
+	h.logTransaction(c, "browser header page")
+	txn := newrelic.FromContext(c.Request.Context())
+	hdr := txn.BrowserTimingHeader()
+	if js := hdr.WithTags(); js != nil {
+		c.Writer.Write(js)
+	}
+	io.WriteString(c.Writer, "browser header page")
+}
+
+//Limited is served behind the rate limiter, throttled requests never reach it
+func (h *Handler) Limited(c *gin.Context) {
+	h.logTransaction(c, "request allowed")
+	io.WriteString(c.Writer, "request allowed")
+}
+
+//Panic panics on purpose to exercise the recovery middleware
+func (h *Handler) Panic(c *gin.Context) {
+	h.logTransaction(c, "panicking")
+	panic("something went terribly wrong")
+}
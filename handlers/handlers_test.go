@@ -0,0 +1,612 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	nrgin "github.com/newrelic/go-agent/v3/integrations/nrgin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/sirupsen/logrus"
+	"github.com/sony/gobreaker"
+
+	"NewRelics-POC/config"
+	"NewRelics-POC/jobs"
+)
+
+//newTestHandler returns a Handler backed by a disabled agent, so transactions are no-ops
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("test"),
+		newrelic.ConfigEnabled(false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	pool := jobs.NewPool(app, 1, 10)
+	t.Cleanup(pool.Stop)
+	return New(app, logger, Deps{Pool: pool})
+}
+
+//serve runs a single request through the New Relic middleware and the handler
+func serve(h *Handler, method, path, body string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	route := path
+	if i := strings.Index(route, "?"); i >= 0 {
+		route = route[:i]
+	}
+	return serveRoute(h, method, route, path, body, handler)
+}
+
+//transactionContext does what server.transactionContext does, so handlers find the transaction
+func transactionContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = newrelic.RequestWithTransactionContext(c.Request, nrgin.Transaction(c))
+	}
+}
+
+//serveRoute is serve for handlers mounted on a route template such as /users/:id
+func serveRoute(h *Handler, method, route, path, body string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(gin.Recovery(), nrgin.Middleware(h.App), transactionContext())
+	router.Handle(method, route, handler)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlers(t *testing.T) {
+	h := newTestHandler(t)
+
+	//external calls are left out, they need network access
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		handler    gin.HandlerFunc
+		wantStatus int
+		wantBody   string
+	}{
+		{"txn", "GET", "/txn", "", h.EndpointAccessTransaction, http.StatusOK, "test Transaction"},
+		{"index", "GET", "/test-connection", "", h.Index, http.StatusOK, "hello world"},
+		{"version", "GET", "/version", "", h.Version, http.StatusOK, `"agent_version":"` + newrelic.Version + `"`},
+		{"whoami", "GET", "/whoami", "", h.Whoami, http.StatusOK, `"hostname":"`},
+		{"notice error", "GET", "/notice_error", "", h.NoticeError, http.StatusInternalServerError, `{"error":{"class":"ExampleError","message":"my error message"}}`},
+		{"notice error with attributes", "GET", "/notice_error_with_attributes", "", h.NoticeErrorWithAttributes, http.StatusOK, "noticing an error"},
+		{"expected error", "GET", "/expected_error", "", h.ExpectedError, http.StatusOK, "noticing an expected error"},
+		{"error vs log", "GET", "/error_vs_log", "", h.ErrorVsLog, http.StatusOK, `"noticed":"PaymentDeclined"`},
+		{"log without message", "GET", "/log?severity=warn", "", h.Log, http.StatusBadRequest, "message is required"},
+		{"custom event", "GET", "/custom_event", "", h.CustomEvent, http.StatusOK, "recording a custom event"},
+		{"dynamic event", "GET", "/event?count=3&name=book", "", h.DynamicEvent, http.StatusOK, `{"count":3,"name":"book"}`},
+		{"dynamic event non-finite numbers", "GET", "/event?x=NaN&y=Inf&z=1e999", "", h.DynamicEvent, http.StatusOK, `{"x":"NaN","y":"Inf","z":"1e999"}`},
+		{"dynamic event too many attributes", "GET", "/event?" + manyParams(65), "", h.DynamicEvent, http.StatusBadRequest, "at most 64 attributes"},
+		{"set name", "GET", "/set_name", "", h.SetName, http.StatusOK, "changing the transaction's name"},
+		{"add attribute", "GET", "/add_attribute", "", h.AddAttribute, http.StatusOK, "adding attributes"},
+		{"segments", "GET", "/segments", "", h.Segments, http.StatusOK, "segments!"},
+		{"segments tree too deep", "GET", "/segments_tree?depth=21", "", h.SegmentsTree, http.StatusBadRequest, "depth must be between 1 and 20"},
+		{"segment lifecycle", "GET", "/segment_lifecycle", "", h.SegmentLifecycle, http.StatusOK, "segment lifecycle done"},
+		{"instrumented", "GET", "/instrumented", "", h.Instrumented, http.StatusOK, `"greeting":"hello from 3 items"`},
+		{"categorized", "GET", "/categorized", "", h.Categorized, http.StatusOK, "categorized segments done"},
+		{"span attributes", "GET", "/span_attributes", "", h.SpanAttributes, http.StatusOK, "span attributes added"},
+		{"continue trace", "GET", "/continue_trace", "", h.ContinueTrace, http.StatusOK, `"trace_id"`},
+		{"custom metric", "GET", "/custommetric", "", h.CustomMetric, http.StatusOK, "custom metric recorded"},
+		{"timing", "GET", "/timing?n=2", "", h.Timing, http.StatusOK, `"count":2`},
+		{"timing invalid", "GET", "/timing?n=101", "", h.Timing, http.StatusBadRequest, "n must be between"},
+		{"browser", "GET", "/browser", "", h.Browser, http.StatusOK, "browser header page"},
+		{"async", "GET", "/async", "", h.Async, http.StatusOK, "done!"},
+		{"fanout", "GET", "/fanout?n=3", "", h.Fanout, http.StatusOK, "3 workers done!"},
+		{"fanout invalid", "GET", "/fanout?n=0", "", h.Fanout, http.StatusBadRequest, "n must be between"},
+		{"message", "GET", "/message", "", h.Message, http.StatusOK, "producing a message queue message"},
+		{"enqueue", "POST", "/enqueue?id=job-1", "", h.Enqueue, http.StatusAccepted, `"id":"job-1"`},
+		{"consume", "GET", "/consume", "", h.Consume, http.StatusOK, "consumed message"},
+		{"process message", "GET", "/process_message?id=m1&fail=2", "", h.ProcessMessage, http.StatusOK, `"retry_count":2`},
+		{"process message dead letter", "GET", "/process_message?id=m1&fail=10", "", h.ProcessMessage, http.StatusUnprocessableEntity, `"class":"DeadLetter"`},
+		{"process message bad fail", "GET", "/process_message?fail=x", "", h.ProcessMessage, http.StatusBadRequest, "ValidationError"},
+		{"slow", "GET", "/slow?ms=1", "", h.Slow, http.StatusOK, "slept for 1ms"},
+		{"load", "GET", "/load?n=1", "", h.Load, http.StatusOK, `"hash":"` + fmt.Sprintf("%x", sha256.Sum256([]byte("load"))) + `"`},
+		{"compare", "GET", "/compare?n=10", "", h.Compare, http.StatusOK, `"iterations":10`},
+		{"compare rounds", "GET", "/compare?n=10", "", h.Compare, http.StatusOK, `"rounds":5`},
+		{"compare bad n", "GET", "/compare?n=0", "", h.Compare, http.StatusBadRequest, "n must be between 1 and 10000"},
+		{"compare n over max", "GET", "/compare?n=10001", "", h.Compare, http.StatusBadRequest, "n must be between 1 and 10000"},
+		{"load invalid", "GET", "/load?n=5000001", "", h.Load, http.StatusBadRequest, "n must be between"},
+		{"stream invalid", "GET", "/stream?n=0", "", h.Stream, http.StatusBadRequest, "n must be between"},
+		{"slow invalid", "GET", "/slow?ms=abc", "", h.Slow, http.StatusBadRequest, "ms must be a non-negative integer"},
+		{"limited", "GET", "/limited", "", h.Limited, http.StatusOK, "request allowed"},
+		{"service demo", "GET", "/service_demo?id=7", "", h.ServiceDemo, http.StatusOK, `"user":{"id":"7"`},
+		{"panic", "GET", "/panic", "", h.Panic, http.StatusInternalServerError, ""},
+		{"create order", "POST", "/orders", `{"id":"42","item":"book","total":9.5}`, h.CreateOrder, http.StatusCreated, `"id":"42"`},
+		{"create order invalid", "POST", "/orders", `{"id":"42"}`, h.CreateOrder, http.StatusBadRequest, `"error"`},
+		{"batch", "POST", "/batch", `[{"id":"a","quantity":1},{"id":"b","quantity":0}]`, h.Batch, http.StatusOK, `[{"id":"a","ok":true},{"id":"b","ok":false,"error":"item b: quantity must be positive, got 0"}]`},
+		{"batch invalid", "POST", "/batch", `{"id":"a"}`, h.Batch, http.StatusBadRequest, `"ValidationError"`},
+		{"healthz", "GET", "/healthz", "", h.Healthz, http.StatusOK, `{"status":"ok"}`},
+		{"readyz", "GET", "/readyz", "", h.Readyz, http.StatusOK, `{"status":"ok"}`},
+		{"trace", "GET", "/trace", "", h.Trace, http.StatusOK, `"traceparent"`},
+		{"flush", "GET", "/flush", "", h.Flush, http.StatusOK, `"event_type":"flush_check"`},
+		{"trace dump", "GET", "/trace_dump", "", h.TraceDump, http.StatusOK, `"entity_guid"`},
+		{"build info", "GET", "/buildinfo", "", h.BuildInfo, http.StatusOK, `"go_version":"go`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, tt.method, tt.path, tt.body, tt.handler)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.Config{AppName: "POC", ExternalMaxAttempts: 4}
+	h := New(nil, logger, Deps{Config: cfg})
+	if h.Config.AppName != "POC" || h.GitHub.MaxAttempts != 4 {
+		t.Errorf("New(Deps{Config: %+v}) gave Config.AppName %q and GitHub.MaxAttempts %d", cfg, h.Config.AppName, h.GitHub.MaxAttempts)
+	}
+	if h := New(nil, logger, Deps{}); h.GitHub.MaxAttempts != 1 {
+		t.Errorf("without ExternalMaxAttempts GitHub.MaxAttempts = %d, want the client's default 1", h.GitHub.MaxAttempts)
+	}
+}
+
+func TestUser(t *testing.T) {
+	h := newTestHandler(t)
+	rec := serveRoute(h, "GET", "/users/:id", "/users/42", "", h.User)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if want := `{"id":"42"}`; rec.Body.String() != want {
+		t.Errorf("body = %q, want %q", rec.Body.String(), want)
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	h := newTestHandler(t)
+	for _, ignored := range []bool{true, false} {
+		rec := serve(h, "GET", "/ignore", "", func(c *gin.Context) {
+			//as server.ignorePaths does for paths in NEW_RELIC_IGNORE_PATHS
+			c.Set(IgnoredKey, ignored)
+			h.Ignore(c)
+		})
+		want := "not ignoring the transaction"
+		if ignored {
+			want = "ignoring the transaction"
+		}
+		if got := rec.Body.String(); got != want {
+			t.Errorf("ignored=%v: body = %q, want %q", ignored, got, want)
+		}
+	}
+}
+
+func TestItem(t *testing.T) {
+	h := newTestHandler(t)
+	rec := serveRoute(h, "GET", "/items/:category/:id", "/items/books/7", "", h.Item)
+	if want := `{"category":"books","id":"7"}`; rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("GET /items/books/7 = %d %q, want %d %q", rec.Code, rec.Body.String(), http.StatusOK, want)
+	}
+}
+
+func TestAgentConfig(t *testing.T) {
+	h := newTestHandler(t)
+	h.Config = config.Config{AppName: "POC", License: "0123456789abcdef", Addr: ":8000", DistributedTracing: true}
+
+	rec := serve(h, "GET", "/config", "", h.AgentConfig)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{`"app_name":"POC"`, `"license":"************cdef"`, `"listen_addr":":8000"`, `"distributed_tracing":true`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body = %q, want it to contain %q", body, want)
+		}
+	}
+	if strings.Contains(body, "0123456789abcdef") {
+		t.Errorf("body leaks the license key: %q", body)
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/notice_error?x=1", nil)
+	c.Request.Header.Set("User-Agent", "test-agent")
+
+	plain := requestError(c, errors.New("boom"))
+	if plain.Class != "*errors.errorString" {
+		t.Errorf("Class = %q, want %q", plain.Class, "*errors.errorString")
+	}
+	for key, want := range map[string]interface{}{"request.url": "/notice_error?x=1", "request.method": "GET", "user.agent": "test-agent"} {
+		if got := plain.Attributes[key]; got != want {
+			t.Errorf("Attributes[%q] = %v, want %v", key, got, want)
+		}
+	}
+
+	classed := requestError(c, newrelic.Error{Message: "bad", Class: "ValidationError", Attributes: map[string]interface{}{"field": "id"}})
+	if classed.Class != "ValidationError" || classed.Attributes["field"] != "id" || classed.Attributes["user.agent"] != "test-agent" {
+		t.Errorf("requestError(newrelic.Error) = %+v, want its class and attributes kept", classed)
+	}
+}
+
+//uploadRequest builds a multipart /upload request carrying content as the "file" field named name
+func uploadRequest(t *testing.T, name, content string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, content)
+	w.Close()
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func TestUpload(t *testing.T) {
+	h := newTestHandler(t)
+	h.Config.UploadDir = t.TempDir()
+	h.Config.UploadLimit = 1024
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(nrgin.Middleware(h.App))
+	router.POST("/upload", h.Upload)
+
+	//the same name twice and a name that is no file name at all are each stored apart
+	uploads := []struct{ name, content string }{{"hello.txt", "first"}, {"hello.txt", "second"}, {"..", "dots"}}
+	stored := map[string]string{}
+	for _, u := range uploads {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, uploadRequest(t, u.name, u.content))
+		var resp struct {
+			Name     string `json:"name"`
+			StoredAs string `json:"stored_as"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusCreated {
+			t.Fatalf("upload of %q = %d %q, want %d", u.name, rec.Code, rec.Body.String(), http.StatusCreated)
+		}
+		if resp.Name != u.name || !strings.HasPrefix(resp.StoredAs, "upload-") {
+			t.Errorf("upload of %q = %+v, want the client name reported and an upload-* file stored", u.name, resp)
+		}
+		stored[resp.StoredAs] = u.content
+	}
+	if len(stored) != len(uploads) {
+		t.Errorf("stored %d files for %d uploads, an upload overwrote another", len(stored), len(uploads))
+	}
+	for name, content := range stored {
+		if saved, err := os.ReadFile(filepath.Join(h.Config.UploadDir, name)); err != nil || string(saved) != content {
+			t.Errorf("saved file %s = %q, %v, want %q", name, saved, err, content)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, uploadRequest(t, "hello.txt", strings.Repeat("x", 2048)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+//manyParams returns a query string with n distinct keys
+func manyParams(n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = fmt.Sprintf("k%d=%d", i, i)
+	}
+	return strings.Join(params, "&")
+}
+
+func TestInstrument(t *testing.T) {
+	h := newTestHandler(t)
+	txn := h.App.StartTransaction("test")
+	defer txn.End()
+
+	if v, err := instrument(txn, "ok", func() (string, error) { return "done", nil }); v != "done" || err != nil {
+		t.Errorf("instrument() = %q, %v, want %q, nil", v, err, "done")
+	}
+	want := errors.New("boom")
+	if _, err := instrument(txn, "fail", func() (int, error) { return 0, want }); err != want {
+		t.Errorf("instrument() error = %v, want %v", err, want)
+	}
+}
+
+func TestMedianDuration(t *testing.T) {
+	tests := []struct {
+		durations []time.Duration
+		want      time.Duration
+	}{
+		{[]time.Duration{7}, 7},
+		{[]time.Duration{9, 1, 5}, 5},
+		{[]time.Duration{4, 100, 2, 6}, 5},
+		{[]time.Duration{3, 3, 1000, 2, 4}, 3},
+	}
+	for _, tt := range tests {
+		if got := medianDuration(append([]time.Duration(nil), tt.durations...)); got != tt.want {
+			t.Errorf("medianDuration(%v) = %v, want %v", tt.durations, got, tt.want)
+		}
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	breaker := newBreaker(nil, nil, "test")
+	fail := func() (interface{}, error) { return nil, errors.New("down") }
+	for i := 0; i < breakerTrip; i++ {
+		breaker.Execute(fail)
+	}
+	if breaker.State() != gobreaker.StateOpen {
+		t.Errorf("state = %s after %d failures, want open", breaker.State(), breakerTrip)
+	}
+	if _, err := breaker.Execute(fail); !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Errorf("Execute() on an open breaker error = %v, want %v", err, gobreaker.ErrOpenState)
+	}
+}
+
+//headerSpy records the Authorization header of the requests passing through it
+type headerSpy struct {
+	next http.RoundTripper
+	seen string
+}
+
+func (s *headerSpy) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.seen = req.Header.Get("Authorization")
+	return s.next.RoundTrip(req)
+}
+
+func TestRedactTransport(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	defer upstream.Close()
+
+	spy := &headerSpy{next: restoreTransport{base: http.DefaultTransport}}
+	client := &http.Client{Transport: redactTransport{next: spy}}
+	req, _ := http.NewRequest("GET", upstream.URL, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	sent, _ := io.ReadAll(resp.Body)
+
+	if spy.seen != "" {
+		t.Errorf("instrumentation saw Authorization = %q, want it redacted", spy.seen)
+	}
+	if string(sent) != "Bearer secret" {
+		t.Errorf("upstream got Authorization = %q, want %q", sent, "Bearer secret")
+	}
+	if req.Header.Get("Authorization") != "Bearer secret" {
+		t.Error("the caller's request was modified")
+	}
+}
+
+func TestNextHarvest(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		now  time.Duration
+		want time.Duration
+	}{
+		{0, time.Minute},
+		{10 * time.Second, time.Minute},
+		{time.Minute, 2 * time.Minute},
+		{150 * time.Second, 3 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := nextHarvest(start, start.Add(tt.now), time.Minute); !got.Equal(start.Add(tt.want)) {
+			t.Errorf("nextHarvest(+%s) = %s, want %s", tt.now, got, start.Add(tt.want))
+		}
+	}
+}
+
+func TestBuildSettings(t *testing.T) {
+	info := &debug.BuildInfo{
+		GoVersion: "go1.22.0",
+		Main:      debug.Module{Path: "NewRelics-POC", Version: "(devel)"},
+		Settings: []debug.BuildSetting{
+			{Key: "-trimpath", Value: "true"},
+			{Key: "vcs.revision", Value: "abc123"},
+			{Key: "vcs.time", Value: "2024-01-01T12:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+	want := gin.H{
+		"module":       "NewRelics-POC",
+		"version":      "(devel)",
+		"go_version":   "go1.22.0",
+		"vcs_revision": "abc123",
+		"vcs_time":     "2024-01-01T12:00:00Z",
+		"vcs_modified": true,
+	}
+	if got := buildSettings(info); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildSettings() = %v, want %v", got, want)
+	}
+}
+
+func TestMaskURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"redis://:secret@localhost:6379/0", "redis://:xxxxx@localhost:6379/0"},
+		{"postgres://app:secret@db:5432/poc", "postgres://app:xxxxx@db:5432/poc"},
+		{"app:secret@tcp(db:3306)/poc", "app:xxxxx@tcp(db:3306)/poc"},
+		{"postgres://db/poc?password=secret&sslmode=disable", "postgres://db/poc?password=xxxxx&sslmode=disable"},
+		{"postgres://app:secret@db/poc?Password=other", "postgres://app:xxxxx@db/poc?Password=xxxxx"},
+		{"host=db user=app password=secret dbname=poc", "host=db user=app password=xxxxx dbname=poc"},
+		{"host=db password='s3cret word' dbname=poc", "host=db password=xxxxx dbname=poc"},
+		{"https://api.github.com", "https://api.github.com"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := maskURL(tt.raw); got != tt.want {
+			t.Errorf("maskURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   string
+	}{
+		{"healthy", http.StatusOK, `","reachable":true,"status":200}]`},
+		{"client error still serving", http.StatusForbidden, `","reachable":true,"status":403}]`},
+		{"failing", http.StatusServiceUnavailable, `","reachable":false,"status":503,"error":"upstream answered 503 Service Unavailable"}]`},
+	}
+	for _, tt := range tests {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(tt.status) }))
+		h := newTestHandler(t)
+		h.GitHub.BaseURL = upstream.URL
+		rec := serve(h, "GET", "/dependencies", "", h.Dependencies)
+		upstream.Close()
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, http.StatusOK)
+		}
+		want := `[{"name":"github","kind":"external","url":"` + upstream.URL + tt.want
+		if rec.Body.String() != want {
+			t.Errorf("%s: body = %q, want %q", tt.name, rec.Body.String(), want)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	defer func(old time.Duration) { streamInterval = old }(streamInterval)
+	streamInterval = time.Millisecond
+
+	h := newTestHandler(t)
+	rec := serve(h, "GET", "/stream?n=3", "", h.Stream)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want %q", got, "text/event-stream")
+	}
+	if got := strings.Count(rec.Body.String(), "event: tick\n"); got != 3 {
+		t.Errorf("got %d events, want 3 in %q", got, rec.Body.String())
+	}
+	if !rec.Flushed {
+		t.Error("the stream was never flushed")
+	}
+}
+
+func TestClassifyHTTPError(t *testing.T) {
+	dns := &url.Error{Op: "Get", URL: "https://nowhere.invalid", Err: &net.DNSError{Err: "no such host", Name: "nowhere.invalid", IsNotFound: true}}
+	timeout := &url.Error{Op: "Get", URL: "https://api.github.com", Err: context.DeadlineExceeded}
+	tests := []struct {
+		name       string
+		err        error
+		resp       *http.Response
+		wantClass  string
+		wantFailed bool
+	}{
+		{"success", nil, &http.Response{StatusCode: http.StatusOK}, "", false},
+		{"dns", dns, nil, classExternalDNS, true},
+		{"timeout", timeout, nil, classExternalTimeout, true},
+		{"not found", nil, &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found"}, classExternalStatus, true},
+		{"5xx after retries", errors.New("upstream answered 503"), &http.Response{StatusCode: http.StatusServiceUnavailable}, classExternalStatus, true},
+		{"connection refused", errors.New("connection refused"), nil, classExternalError, true},
+	}
+	for _, tt := range tests {
+		got, failed := classifyHTTPError(tt.err, tt.resp)
+		if failed != tt.wantFailed || got.Class != tt.wantClass {
+			t.Errorf("%s: classifyHTTPError() = %q, %v, want %q, %v", tt.name, got.Class, failed, tt.wantClass, tt.wantFailed)
+		}
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	h := newTestHandler(t)
+	h.simulator = newSimulator(1)
+
+	failures := 0
+	for i := 0; i < 100; i++ {
+		rec := serve(h, "GET", "/simulate?rate=0.3", "", h.Simulate)
+		switch rec.Code {
+		case http.StatusInternalServerError:
+			failures++
+			if !strings.Contains(rec.Body.String(), `"class":"SimulatedFailure"`) {
+				t.Errorf("body = %q, want a SimulatedFailure", rec.Body.String())
+			}
+		case http.StatusOK:
+		default:
+			t.Fatalf("status = %d, want 200 or 500", rec.Code)
+		}
+	}
+	//the seed makes the count reproducible, it only has to be near 30
+	if failures < 20 || failures > 40 {
+		t.Errorf("%d of 100 requests failed, want about 30", failures)
+	}
+
+	for _, rate := range []string{"0", "1"} {
+		want := map[string]int{"0": http.StatusOK, "1": http.StatusInternalServerError}[rate]
+		if rec := serve(h, "GET", "/simulate?rate="+rate, "", h.Simulate); rec.Code != want {
+			t.Errorf("rate=%s: status = %d, want %d", rate, rec.Code, want)
+		}
+	}
+	if rec := serve(h, "GET", "/simulate?rate=2", "", h.Simulate); rec.Code != http.StatusBadRequest {
+		t.Errorf("rate=2: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestExternal(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users/defunkt" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `{"login":"defunkt","id":2,"name":"Chris Wanstrath"}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t)
+	h.GitHub.BaseURL = upstream.URL
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"/external", http.StatusOK, `"login":"defunkt","id":2,"name":"Chris Wanstrath"`},
+		{"/external?login=nobody", http.StatusNotFound, `"class":"ExternalStatus"`},
+	}
+	for _, tt := range tests {
+		rec := serve(h, "GET", tt.path, "", h.External)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.path, rec.Code, tt.wantStatus)
+		}
+		if !strings.Contains(rec.Body.String(), tt.wantBody) {
+			t.Errorf("%s: body = %q, want it to contain %q", tt.path, rec.Body.String(), tt.wantBody)
+		}
+	}
+	if h.Breaker.State() != gobreaker.StateClosed {
+		t.Errorf("breaker state = %s after a 404, want closed", h.Breaker.State())
+	}
+}
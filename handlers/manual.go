@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+/*
+Manual is a plain http.HandlerFunc instrumented by hand, the way code outside
+gin has to do what nrgin does for the other routes:
+  - StartTransaction names the transaction
+  - SetWebRequestHTTP marks it as a web transaction, records the request
+    attributes and accepts the inbound distributed trace headers
+  - SetWebResponse wraps w, so the status code and response headers are
+    recorded and a status of 500 or more is noticed as an error
+  - RequestWithTransactionContext puts the transaction on the request
+    context for the code called from here
+
+It is mounted ahead of the nrgin middleware, which would otherwise start a
+second transaction for the same request.
+*/
+func (h *Handler) Manual(w http.ResponseWriter, r *http.Request) {
+	txn := h.App.StartTransaction("GET /manual")
+	defer txn.End()
+
+	txn.SetWebRequestHTTP(r)
+	w = txn.SetWebResponse(w)
+	r = newrelic.RequestWithTransactionContext(r, txn)
+	h.Logger.WithContext(r.Context()).Info("serving a manually instrumented request")
+
+	seg := txn.StartSegment("manual-work")
+	time.Sleep(5 * time.Millisecond)
+	seg.End()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "manually instrumented")
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//add mesage in the segment
+func (h *Handler) Message(c *gin.Context) {
+	h.logTransaction(c, "producing a message queue message")
+	txn := newrelic.FromContext(c.Request.Context())
+	s := newrelic.MessageProducerSegment{
+		StartTime:       newrelic.StartSegmentNow(txn),
+		Library:         "Library",
+		DestinationType: newrelic.MessageQueue,
+		DestinationName: "Destination name",
+	}
+	defer s.End()
+
+	time.Sleep(20 * time.Millisecond)
+	io.WriteString(c.Writer, `producing a message queue message`)
+}
+
+//queueMessage is a simulated message as it would arrive from a broker
+type queueMessage struct {
+	ID          string
+	Destination string
+	Headers     http.Header
+	Body        string
+}
+
+/*
+processMessage handles a message in its own transaction named after the
+destination. A real consumer pulls the distributed trace headers the
+producer attached to the message and accepts them, so the consumer
+transaction joins the producer's trace.
+*/
+func processMessage(app *newrelic.Application, msg queueMessage) {
+	txn := app.StartTransaction("consume " + msg.Destination)
+	defer txn.End()
+
+	txn.AcceptDistributedTraceHeaders(newrelic.TransportQueue, msg.Headers)
+	txn.AddAttribute("message.id", msg.ID)
+
+	seg := txn.StartSegment("process-message")
+	time.Sleep(15 * time.Millisecond)
+	seg.End()
+}
+
+//Consume simulates pulling a message off the queue and processing it
+func (h *Handler) Consume(c *gin.Context) {
+	h.logTransaction(c, "consuming a message queue message")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	msg := queueMessage{
+		ID:          strconv.FormatInt(time.Now().UnixNano(), 10),
+		Destination: "Destination name",
+		Headers:     http.Header{},
+		Body:        "hello world",
+	}
+	//the producer side would attach these headers when publishing
+	txn.InsertDistributedTraceHeaders(msg.Headers)
+
+	processMessage(h.App, msg)
+	io.WriteString(c.Writer, "consumed message "+msg.ID)
+}
+
+//maxMessageRetries is how often /process_message retries a message before dead-lettering it
+const maxMessageRetries = 3
+
+//errMessageFailed is the simulated failure of a /process_message attempt
+var errMessageFailed = errors.New("message processing failed")
+
+/*
+ProcessMessage simulates a consumer with a dead letter queue. The first ?fail=
+attempts at the message fail, each retry bumps the retry_count attribute, and
+once maxMessageRetries retries have failed too the message is recorded as a
+dead_letter custom event carrying its id, so failed messages can be queried.
+Every attempt runs in its own consume segment.
+*/
+func (h *Handler) ProcessMessage(c *gin.Context) {
+	h.logTransaction(c, "processing a message")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	fail := 0
+	if value := c.Query("fail"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			respondError(c, http.StatusBadRequest, "ValidationError", fmt.Sprintf("fail must be a non-negative integer, got %q", value))
+			return
+		}
+		fail = n
+	}
+	msg := queueMessage{ID: c.DefaultQuery("id", uuid.NewString()), Destination: "Destination name"}
+	txn.AddAttribute("message.id", msg.ID)
+
+	for attempt := 0; attempt <= maxMessageRetries; attempt++ {
+		txn.AddAttribute("retry_count", attempt)
+		seg := txn.StartSegment("MessageBroker/" + msg.Destination + "/Consume")
+		seg.AddAttribute("attempt", attempt+1)
+		time.Sleep(10 * time.Millisecond)
+		seg.End()
+		if attempt >= fail {
+			c.JSON(http.StatusOK, gin.H{"id": msg.ID, "retry_count": attempt, "dead_letter": false})
+			return
+		}
+	}
+
+	h.recordEvent("dead_letter", map[string]interface{}{
+		"message.id":  msg.ID,
+		"destination": msg.Destination,
+		"retry_count": maxMessageRetries,
+		"error":       errMessageFailed.Error(),
+	})
+	respondError(c, http.StatusUnprocessableEntity, "DeadLetter", fmt.Sprintf("message %s dead-lettered after %d retries: %v", msg.ID, maxMessageRetries, errMessageFailed))
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//order is the JSON payload accepted by /orders
+type order struct {
+	ID    string  `json:"id" binding:"required"`
+	Item  string  `json:"item" binding:"required"`
+	Total float64 `json:"total" binding:"required,gt=0"`
+}
+
+//CreateOrder validates the body and records the order on the transaction
+func (h *Handler) CreateOrder(c *gin.Context) {
+	h.logTransaction(c, "creating an order")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	var o order
+	if err := c.ShouldBindJSON(&o); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	if txn != nil {
+		txn.AddAttribute("order.id", o.ID)
+		txn.AddAttribute("order.total", o.Total)
+	}
+	c.JSON(http.StatusCreated, o)
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+PGTime asks PostgreSQL for the current time with the request context, so the
+nrpgx5 tracer records the query as a datastore segment on the transaction.
+*/
+func (h *Handler) PGTime(c *gin.Context) {
+	h.logTransaction(c, "querying postgres for the time")
+	var now time.Time
+	if err := h.PG.QueryRow(c.Request.Context(), "SELECT now()").Scan(&now); err != nil {
+		respondError(c, http.StatusInternalServerError, "DatabaseError", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"now": now})
+}
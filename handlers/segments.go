@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+/*
+Segments are the specific parts of a transaction in an application.
+By instrumenting segments, you can measure the time taken by functions and code blocks,
+such as external calls, datastore calls, adding messages to queues, and background tasks.
+*/
+func (h *Handler) Segments(c *gin.Context) {
+	h.logTransaction(c, "segments")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	func() {
+		defer newrelic.StartSegment(txn, "f1").End()
+
+		func() {
+			defer newrelic.StartSegment(txn, "f2").End()
+
+			io.WriteString(c.Writer, "segments!")
+			time.Sleep(10 * time.Millisecond)
+		}()
+		time.Sleep(15 * time.Millisecond)
+	}()
+	time.Sleep(20 * time.Millisecond)
+}
+
+//add transation to go routine.
+func (h *Handler) Async(c *gin.Context) {
+	h.logTransaction(c, "async segment")
+	txn := newrelic.FromContext(c.Request.Context())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func(txn *newrelic.Transaction) {
+		defer wg.Done()
+		defer newrelic.StartSegment(txn, "async").End()
+		time.Sleep(100 * time.Millisecond)
+	}(txn)
+
+	segment := newrelic.StartSegment(txn, "wg.Wait")
+	wg.Wait()
+	segment.End()
+	c.Writer.Write([]byte("done!"))
+}
+
+//bounds for the nesting depth of /segments_tree
+const (
+	defaultTreeDepth = 5
+	maxTreeDepth     = 20
+)
+
+//SegmentsTree nests ?depth= segments named level-0, level-1, ... each inside the previous one
+func (h *Handler) SegmentsTree(c *gin.Context) {
+	h.logTransaction(c, "building a segment tree")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	depth := defaultTreeDepth
+	if value := c.Query("depth"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxTreeDepth {
+			respondError(c, http.StatusBadRequest, "ValidationError", fmt.Sprintf("depth must be between 1 and %d", maxTreeDepth))
+			return
+		}
+		depth = parsed
+	}
+	nestSegments(txn, 0, depth)
+	io.WriteString(c.Writer, fmt.Sprintf("nested %d segments", depth))
+}
+
+//nestSegments starts level-<level> and, inside it, the levels below it down to depth
+func nestSegments(txn *newrelic.Transaction, level, depth int) {
+	if level >= depth {
+		return
+	}
+	defer newrelic.StartSegment(txn, fmt.Sprintf("level-%d", level)).End()
+	time.Sleep(2 * time.Millisecond)
+	nestSegments(txn, level+1, depth)
+}
+
+//bounds for the number of /fanout workers
+const (
+	defaultFanout = 5
+	maxFanout     = 100
+)
+
+//Fanout runs ?n= workers concurrently, each in its own segment of the same transaction
+func (h *Handler) Fanout(c *gin.Context) {
+	h.logTransaction(c, "fanning out")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	n := defaultFanout
+	if value := c.Query("n"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxFanout {
+			respondError(c, http.StatusBadRequest, "ValidationError", fmt.Sprintf("n must be between 1 and %d", maxFanout))
+			return
+		}
+		n = parsed
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		//each goroutine needs its own handle on the transaction
+		go func(i int, txn *newrelic.Transaction) {
+			defer wg.Done()
+			defer newrelic.StartSegment(txn, fmt.Sprintf("worker-%d", i)).End()
+			time.Sleep(time.Duration(10+rand.Intn(40)) * time.Millisecond)
+		}(i, txn.NewGoroutine())
+	}
+
+	segment := newrelic.StartSegment(txn, "wg.Wait")
+	wg.Wait()
+	segment.End()
+	io.WriteString(c.Writer, fmt.Sprintf("%d workers done!", n))
+}
+
+//bounds for the artificial latency of /slow, in milliseconds
+const (
+	defaultSlowMs = 500
+	maxSlowMs     = 10000
+)
+
+/*
+Slow sleeps for ?ms= milliseconds to generate a range of response times. It
+stops early without answering when the request context is done, leaving the
+response to the timeout middleware.
+*/
+func (h *Handler) Slow(c *gin.Context) {
+	h.logTransaction(c, "sleeping")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	ms := defaultSlowMs
+	if value := c.Query("ms"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			respondError(c, http.StatusBadRequest, "ValidationError", fmt.Sprintf("ms must be a non-negative integer, got %q", value))
+			return
+		}
+		ms = n
+	}
+	if ms > maxSlowMs {
+		ms = maxSlowMs
+	}
+
+	seg := newrelic.StartSegment(txn, "artificial-delay")
+	select {
+	case <-time.After(time.Duration(ms) * time.Millisecond):
+		seg.End()
+	case <-c.Request.Context().Done():
+		seg.End()
+		return
+	}
+	io.WriteString(c.Writer, fmt.Sprintf("slept for %dms", ms))
+}
+
+//bounds for the number of hashing rounds of /load
+const (
+	defaultLoadIterations = 100000
+	maxLoadIterations     = 5000000
+)
+
+/*
+Load burns CPU by hashing its own output ?n= times in a cpu-work segment, and
+records the time taken as Custom/CPUWorkMs. Unlike Slow, which waits, the
+time goes into the segment's own work, to compare how CPU-bound and
+I/O-bound slow transactions show up in traces.
+*/
+func (h *Handler) Load(c *gin.Context) {
+	h.logTransaction(c, "generating cpu load")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	n := defaultLoadIterations
+	if value := c.Query("n"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxLoadIterations {
+			respondError(c, http.StatusBadRequest, "ValidationError", fmt.Sprintf("n must be between 1 and %d", maxLoadIterations))
+			return
+		}
+		n = parsed
+	}
+
+	start := time.Now()
+	seg := txn.StartSegment("cpu-work")
+	sum := sha256.Sum256([]byte("load"))
+	for i := 1; i < n; i++ {
+		sum = sha256.Sum256(sum[:])
+	}
+	seg.AddAttribute("iterations", n)
+	seg.End()
+	elapsed := float64(time.Since(start)) / float64(time.Millisecond)
+	recordCustom(txn.Application(), "CPUWorkMs", elapsed)
+
+	c.JSON(http.StatusOK, gin.H{"iterations": n, "duration_ms": elapsed, "hash": hex.EncodeToString(sum[:])})
+}
+
+/*
+bounds for the number of workload units /compare times per round. Every
+instrumented unit is a segment, and the agent keeps only a limited number of
+segments in a transaction trace and of span events per harvest, so a larger
+n would mostly time segments that are dropped anyway.
+*/
+const (
+	defaultCompareIterations = 1000
+	maxCompareIterations     = 10000
+)
+
+//compareRounds is how many plain and instrumented passes /compare alternates between
+const compareRounds = 5
+
+//compareUnit is the workload /compare runs, a few rounds of hashing
+func compareUnit(seed [32]byte) [32]byte {
+	for i := 0; i < 10; i++ {
+		seed = sha256.Sum256(seed[:])
+	}
+	return seed
+}
+
+//medianDuration returns the median of durations, sorting them in place
+func medianDuration(durations []time.Duration) time.Duration {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	mid := len(durations) / 2
+	if len(durations)%2 == 0 {
+		return (durations[mid-1] + durations[mid]) / 2
+	}
+	return durations[mid]
+}
+
+/*
+Compare runs ?n= units of the same workload plainly and with each unit in its
+own segment, alternating the two for compareRounds rounds so drift in CPU
+frequency or load hits both alike. It reports the median duration of each and
+the overhead of the segments in percent, also recorded as
+Custom/InstrumentationOverheadPct. A warm-up pass runs first so neither pays
+for cold caches. One segment per unit of microseconds is the worst case, real
+segments wrap far larger units of work and cost proportionally less.
+*/
+func (h *Handler) Compare(c *gin.Context) {
+	h.logTransaction(c, "comparing instrumented and plain code")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	n := defaultCompareIterations
+	if value := c.Query("n"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxCompareIterations {
+			respondError(c, http.StatusBadRequest, "ValidationError", fmt.Sprintf("n must be between 1 and %d", maxCompareIterations))
+			return
+		}
+		n = parsed
+	}
+
+	var sum [32]byte
+	for i := 0; i < n; i++ {
+		sum = compareUnit(sum)
+	}
+
+	plainRounds := make([]time.Duration, 0, compareRounds)
+	instrumentedRounds := make([]time.Duration, 0, compareRounds)
+	for round := 0; round < compareRounds; round++ {
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			sum = compareUnit(sum)
+		}
+		plainRounds = append(plainRounds, time.Since(start))
+
+		start = time.Now()
+		for i := 0; i < n; i++ {
+			seg := txn.StartSegment("compare-unit")
+			sum = compareUnit(sum)
+			seg.End()
+		}
+		instrumentedRounds = append(instrumentedRounds, time.Since(start))
+	}
+	plain := medianDuration(plainRounds)
+	instrumented := medianDuration(instrumentedRounds)
+
+	var overhead float64
+	if plain > 0 {
+		overhead = (float64(instrumented) - float64(plain)) / float64(plain) * 100
+	}
+	recordCustom(txn.Application(), "InstrumentationOverheadPct", overhead)
+	c.JSON(http.StatusOK, gin.H{
+		"iterations":      n,
+		"rounds":          compareRounds,
+		"plain_ms":        float64(plain) / float64(time.Millisecond),
+		"instrumented_ms": float64(instrumented) / float64(time.Millisecond),
+		"overhead_pct":    overhead,
+		"hash":            hex.EncodeToString(sum[:]),
+	})
+}
+
+/*
+SpanAttributes attaches attributes to individual segments. They land on the
+span events of those segments only, while transaction attributes are copied
+to the transaction, its errors and its root span.
+*/
+func (h *Handler) SpanAttributes(c *gin.Context) {
+	h.logTransaction(c, "adding span attributes")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	query := newrelic.StartSegment(txn, "load-rows")
+	time.Sleep(10 * time.Millisecond)
+	query.AddAttribute("db.rows", 42)
+	query.AddAttribute("db.table", "users")
+	query.End()
+
+	cache := newrelic.StartSegment(txn, "cache-lookup")
+	time.Sleep(5 * time.Millisecond)
+	cache.AddAttribute("cache.hit", true)
+	cache.AddAttribute("cache.key", "user:42")
+	cache.End()
+
+	io.WriteString(c.Writer, "span attributes added")
+}
+
+/*
+startCategorizedSegment starts a generic segment tagged with a category
+attribute, such as "compute" or "io". The agent's own span category stays
+generic, the attribute only lands on the segment's span so traces of mixed
+workloads can be faceted by it.
+*/
+func startCategorizedSegment(txn *newrelic.Transaction, name, category string) *newrelic.Segment {
+	seg := txn.StartSegment(name)
+	seg.AddAttribute("category", category)
+	return seg
+}
+
+//Categorized runs three segments of different categories
+func (h *Handler) Categorized(c *gin.Context) {
+	h.logTransaction(c, "running categorized segments")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	seg := startCategorizedSegment(txn, "score-items", "compute")
+	time.Sleep(10 * time.Millisecond)
+	seg.End()
+
+	seg = startCategorizedSegment(txn, "read-file", "io")
+	time.Sleep(5 * time.Millisecond)
+	seg.End()
+
+	seg = startCategorizedSegment(txn, "encode-response", "serialization")
+	time.Sleep(2 * time.Millisecond)
+	seg.End()
+
+	io.WriteString(c.Writer, "categorized segments done")
+}
+
+//instrument runs fn in a segment called name and notices any error it returns
+func instrument[T any](txn *newrelic.Transaction, name string, fn func() (T, error)) (T, error) {
+	seg := txn.StartSegment(name)
+	v, err := fn()
+	seg.End()
+	if err != nil {
+		txn.NoticeError(err)
+	}
+	return v, err
+}
+
+//Instrumented times two blocks of differing result types with instrument
+func (h *Handler) Instrumented(c *gin.Context) {
+	h.logTransaction(c, "running instrumented functions")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	count, err := instrument(txn, "count-items", func() (int, error) {
+		time.Sleep(5 * time.Millisecond)
+		return 3, nil
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "InstrumentedError", err.Error())
+		return
+	}
+	greeting, err := instrument(txn, "build-greeting", func() (string, error) {
+		time.Sleep(5 * time.Millisecond)
+		return fmt.Sprintf("hello from %d items", count), nil
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "InstrumentedError", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"count": count, "greeting": greeting})
+}
+
+/*
+SegmentLifecycle shows three ways a segment can end:
+  - deferred: End runs when the enclosing function returns, so the segment
+    covers everything after StartSegment, including work added later
+  - explicit: End is called by hand and the segment covers exactly the code
+    between the two calls, but an early return can skip it
+  - never ended: the agent drops a segment that is not ended before the
+    transaction, so it shows up in neither the trace nor the span events and
+    its time is counted as the parent's exclusive time
+*/
+func (h *Handler) SegmentLifecycle(c *gin.Context) {
+	h.logTransaction(c, "segment lifecycle")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	func() {
+		defer newrelic.StartSegment(txn, "deferred").End()
+		time.Sleep(10 * time.Millisecond)
+	}()
+
+	seg := newrelic.StartSegment(txn, "explicit")
+	time.Sleep(10 * time.Millisecond)
+	seg.End()
+
+	//nothing ends this one, so it is not reported
+	newrelic.StartSegment(txn, "never-ended")
+	time.Sleep(10 * time.Millisecond)
+
+	io.WriteString(c.Writer, "segment lifecycle done")
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+ServiceDemo calls into the service layer with the request context. The gin
+middleware put the transaction on that context, so the segments the service
+functions start are children of this request's transaction.
+*/
+func (h *Handler) ServiceDemo(c *gin.Context) {
+	h.logTransaction(c, "calling the service layer")
+	ctx := c.Request.Context()
+	id := c.DefaultQuery("id", "42")
+
+	u, err := h.Service.FetchUser(ctx, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "ServiceError", err.Error())
+		return
+	}
+	orders, err := h.Service.FetchOrders(ctx, u.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "ServiceError", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": u, "orders": orders})
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+//defaultFailureRate is the share of /simulate requests failing when ?rate= is not given
+const defaultFailureRate = 0.1
+
+//simulator decides which /simulate requests fail, rand.Rand is not safe for concurrent use
+type simulator struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+//newSimulator returns a simulator whose decisions are reproducible for a given seed
+func newSimulator(seed int64) *simulator {
+	return &simulator{rnd: rand.New(rand.NewSource(seed))}
+}
+
+//fail reports whether the next request fails, true for a share rate of the calls
+func (s *simulator) fail(rate float64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Float64() < rate
+}
+
+/*
+Simulate fails ?rate= of the requests, 0.3 fails about 30% of them, with a
+500 noticed as a SimulatedFailure. Driving it at a steady pace produces a
+controlled error rate for tuning alert thresholds.
+*/
+func (h *Handler) Simulate(c *gin.Context) {
+	h.logTransaction(c, "simulating an error rate")
+	rate := defaultFailureRate
+	if value := c.Query("rate"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			respondError(c, http.StatusBadRequest, "ValidationError", fmt.Sprintf("rate must be between 0 and 1, got %q", value))
+			return
+		}
+		rate = parsed
+	}
+
+	if h.simulator.fail(rate) {
+		respondError(c, http.StatusInternalServerError, "SimulatedFailure", fmt.Sprintf("simulated failure at rate %g", rate))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "rate": rate})
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//bounds for the number of events sent by /stream
+const (
+	defaultStreamEvents = 5
+	maxStreamEvents     = 40
+)
+
+//streamInterval is the pause between two /stream events, a variable so tests can shorten it
+var streamInterval = 500 * time.Millisecond
+
+/*
+Stream sends ?n= server-sent events, one every streamInterval. Each write
+and flush is its own sse-write segment and the bytes sent are recorded as
+Custom/StreamBytes. The transaction stays open for the whole stream and ends
+when the handler returns, either after the last event or as soon as the
+client goes away, in which case stream.disconnected=true is added.
+*/
+func (h *Handler) Stream(c *gin.Context) {
+	h.logTransaction(c, "streaming events")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	n := defaultStreamEvents
+	if value := c.Query("n"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxStreamEvents {
+			respondError(c, http.StatusBadRequest, "ValidationError", fmt.Sprintf("n must be between 1 and %d", maxStreamEvents))
+			return
+		}
+		n = parsed
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+
+	total := 0
+	defer func() {
+		txn.AddAttribute("stream.events", n)
+		txn.AddAttribute("stream.bytes", total)
+		recordCustom(txn.Application(), "StreamBytes", float64(total))
+	}()
+	for i := 1; i <= n; i++ {
+		seg := txn.StartSegment("sse-write")
+		written, _ := fmt.Fprintf(c.Writer, "id: %d\nevent: tick\ndata: {\"seq\":%d,\"time\":%q}\n\n", i, i, time.Now().UTC().Format(time.RFC3339Nano))
+		c.Writer.Flush()
+		seg.End()
+		total += written
+		if i == n {
+			break
+		}
+
+		select {
+		case <-time.After(streamInterval):
+		case <-c.Request.Context().Done():
+			txn.AddAttribute("stream.disconnected", true)
+			n = i
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+/*
+ContinueTrace accepts the traceparent/tracestate and newrelic headers of the
+inbound request so the transaction joins the caller's trace, the receiving
+half of what Trace and ExternalManual insert. nrgin already does this for
+every request, the explicit call shows what a service without a framework
+integration has to do; headers must be accepted before any segment is
+started or outbound headers are created. The resulting trace id is returned.
+*/
+func (h *Handler) ContinueTrace(c *gin.Context) {
+	h.logTransaction(c, "continuing an upstream trace")
+	txn := newrelic.FromContext(c.Request.Context())
+	txn.AcceptDistributedTraceHeaders(newrelic.TransportHTTP, c.Request.Header)
+
+	md := txn.GetTraceMetadata()
+	c.JSON(http.StatusOK, gin.H{
+		"trace_id":    md.TraceID,
+		"span_id":     md.SpanID,
+		"traceparent": c.GetHeader("traceparent"),
+	})
+}
+
+//linkedMessage is handed to the /linked_async worker over a channel, carrying the trace headers like a queue message would
+type linkedMessage struct {
+	Headers http.Header
+	//Done receives the trace id the worker's transaction ended up in
+	Done chan string
+}
+
+/*
+linkedWorker handles every message in a transaction of its own, linked to the
+sender through the headers on the message. Unlike Async, which shares one
+transaction with a goroutine, this produces two transactions: the worker's
+shows up as a child of the handler's in the distributed trace.
+*/
+func linkedWorker(app *newrelic.Application, messages <-chan linkedMessage) {
+	for msg := range messages {
+		txn := app.StartTransaction("linked-worker")
+		txn.AcceptDistributedTraceHeaders(newrelic.TransportOther, msg.Headers)
+		seg := txn.StartSegment("linked-work")
+		time.Sleep(20 * time.Millisecond)
+		seg.End()
+		msg.Done <- txn.GetTraceMetadata().TraceID
+		txn.End()
+	}
+}
+
+//LinkedAsync hands work to a goroutine through a channel and links the goroutine's transaction to this one
+func (h *Handler) LinkedAsync(c *gin.Context) {
+	h.logTransaction(c, "linking an async transaction")
+	txn := newrelic.FromContext(c.Request.Context())
+
+	messages := make(chan linkedMessage)
+	defer close(messages)
+	go linkedWorker(h.App, messages)
+
+	msg := linkedMessage{Headers: http.Header{}, Done: make(chan string, 1)}
+	txn.InsertDistributedTraceHeaders(msg.Headers)
+	seg := txn.StartSegment("wait-linked-worker")
+	messages <- msg
+	workerTrace := <-msg.Done
+	seg.End()
+
+	c.JSON(http.StatusOK, gin.H{
+		"trace_id":        txn.GetTraceMetadata().TraceID,
+		"worker_trace_id": workerTrace,
+	})
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+/*
+Upload saves the "file" form field to Config.UploadDir under a
+server-generated upload-* name, so a client cannot pick the name of, and
+overwrite, a file already there. The client's file name is only recorded as
+the upload.filename attribute. The save is timed in its own segment and its
+size is recorded as Custom/UploadBytes. Bodies over Config.UploadLimit are
+cut off by http.MaxBytesReader and answered with 413.
+*/
+func (h *Handler) Upload(c *gin.Context) {
+	h.logTransaction(c, "receiving an upload")
+	txn := newrelic.FromContext(c.Request.Context())
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(h.Config.UploadLimit))
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(c, http.StatusRequestEntityTooLarge, "UploadTooLarge", fmt.Sprintf("upload must not exceed %d bytes", tooLarge.Limit))
+			return
+		}
+		respondError(c, http.StatusBadRequest, "ValidationError", err.Error())
+		return
+	}
+
+	txn.AddAttribute("upload.filename", file.Filename)
+	seg := newrelic.StartSegment(txn, "file-save")
+	stored, err := saveUpload(file, h.Config.UploadDir)
+	seg.End()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "UploadError", err.Error())
+		return
+	}
+
+	recordCustom(txn.Application(), "UploadBytes", float64(file.Size))
+	c.JSON(http.StatusCreated, gin.H{"name": file.Filename, "stored_as": stored, "size": file.Size})
+}
+
+//saveUpload copies file to a new upload-* file in dir and returns its base name
+func saveUpload(file *multipart.FileHeader, dir string) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return filepath.Base(dst.Name()), nil
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//User echoes the :id path parameter, the transaction is named after the route template
+func (h *Handler) User(c *gin.Context) {
+	h.logTransaction(c, "looking up a user")
+	c.JSON(http.StatusOK, gin.H{"id": c.Param("id")})
+}
+
+//Item echoes the :category and :id path parameters, recorded as route.* attributes by the router
+func (h *Handler) Item(c *gin.Context) {
+	h.logTransaction(c, "looking up an item")
+	c.JSON(http.StatusOK, gin.H{"category": c.Param("category"), "id": c.Param("id")})
+}
@@ -0,0 +1,134 @@
+/*
+Package nrtest stands in for the New Relic collector so tests can assert on
+the data the agent would send. The agent's own test application lives in an
+internal package that cannot be imported, so instead the application is
+given a Collector as its HTTP transport: it answers preconnect and connect
+and keeps every harvested payload.
+
+To assert on a handler's instrumentation:
+
+	app, c := nrtest.NewApplication(t)
+	//serve requests instrumented with app
+	app.Shutdown(time.Second) //forces the final harvest
+	c.MetricNames() //now holds "Custom/f1", ...
+
+Nothing is harvested before the agent's 60 second cycle, so a test that ends
+sooner sees only what Shutdown flushed, which also makes the shutdown logic
+itself testable.
+*/
+package nrtest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//Collector keeps the payloads an application sends, by collector method
+type Collector struct {
+	mu       sync.Mutex
+	payloads map[string][][]byte
+}
+
+func (c *Collector) RoundTrip(req *http.Request) (*http.Response, error) {
+	method := req.URL.Query().Get("method")
+	zr, err := gzip.NewReader(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.payloads[method] = append(c.payloads[method], body)
+	c.mu.Unlock()
+
+	reply := `{"return_value":{}}`
+	switch method {
+	case "preconnect":
+		reply = `{"return_value":{"redirect_host":"collector.test"}}`
+	case "connect":
+		//the account ids are needed for the agent to create and accept distributed trace headers
+		reply = `{"return_value":{"agent_run_id":"test-run","entity_guid":"test-guid","account_id":"1","trusted_account_key":"1","primary_application_id":"2"}}`
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader([]byte(reply))),
+		Request:    req,
+	}, nil
+}
+
+//Sent returns the payloads harvested for a collector method such as "metric_data"
+func (c *Collector) Sent(method string) [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.payloads[method]
+}
+
+//Payload returns the payloads harvested for method joined together, to search them for a value
+func (c *Collector) Payload(method string) []byte {
+	var joined []byte
+	for _, payload := range c.Sent(method) {
+		joined = append(joined, payload...)
+	}
+	return joined
+}
+
+//MetricNames returns the set of metric names harvested, scoped and unscoped
+func (c *Collector) MetricNames() map[string]bool {
+	names := map[string]bool{}
+	for _, payload := range c.Sent("metric_data") {
+		//[run id, start, end, [[{"name": ..., "scope": ...}, [values]], ...]]
+		var data []json.RawMessage
+		if json.Unmarshal(payload, &data) != nil || len(data) < 4 {
+			continue
+		}
+		var metrics [][]json.RawMessage
+		if json.Unmarshal(data[3], &metrics) != nil {
+			continue
+		}
+		for _, m := range metrics {
+			var spec struct {
+				Name string `json:"name"`
+			}
+			if len(m) > 0 && json.Unmarshal(m[0], &spec) == nil {
+				names[spec.Name] = true
+			}
+		}
+	}
+	return names
+}
+
+//NewApplication returns an application named name, connected to a new Collector
+func NewApplication(t testing.TB, name string) (*newrelic.Application, *Collector) {
+	t.Helper()
+	c := &Collector{payloads: map[string][][]byte{}}
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName(name),
+		newrelic.ConfigLicense("0123456789012345678901234567890123456789"),
+		func(cfg *newrelic.Config) {
+			cfg.Transport = c
+			cfg.Utilization.DetectAWS = false
+			cfg.Utilization.DetectAzure = false
+			cfg.Utilization.DetectGCP = false
+			cfg.Utilization.DetectPCF = false
+			cfg.Utilization.DetectDocker = false
+			cfg.Utilization.DetectKubernetes = false
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.WaitForConnection(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return app, c
+}
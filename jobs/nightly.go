@@ -0,0 +1,41 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//nightlyJob is a background transaction, it shows up under "Non-web" in the UI
+func nightlyJob(app *newrelic.Application) {
+	txn := app.StartTransaction("nightly-job")
+	defer txn.End()
+
+	func() {
+		defer txn.StartSegment("extract").End()
+		time.Sleep(50 * time.Millisecond)
+	}()
+	func() {
+		defer txn.StartSegment("transform").End()
+		time.Sleep(30 * time.Millisecond)
+	}()
+	func() {
+		defer txn.StartSegment("load").End()
+		time.Sleep(20 * time.Millisecond)
+	}()
+}
+
+//RunNightly runs the nightly job every interval until ctx is cancelled
+func RunNightly(ctx context.Context, app *newrelic.Application, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			nightlyJob(app)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//Job is a unit of work queued on a Pool
+type Job struct {
+	ID      string `json:"id"`
+	Payload string `json:"payload"`
+}
+
+/*
+Pool is a fixed set of workers reading jobs from a buffered channel. Every job
+runs in its own background transaction, so queued work is reported under
+"Non-web" separately from the request that enqueued it.
+*/
+type Pool struct {
+	app  *newrelic.Application
+	jobs chan Job
+	wg   sync.WaitGroup
+	//mu guards closed, so no Enqueue sends on the queue once Stop has closed it
+	mu     sync.RWMutex
+	closed bool
+}
+
+//NewPool starts workers goroutines sharing a queue of up to queueSize jobs
+func NewPool(app *newrelic.Application, workers, queueSize int) *Pool {
+	p := &Pool{app: app, jobs: make(chan Job, queueSize)}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+/*
+Enqueue adds job to the queue without blocking. It reports false when the
+queue is full, so callers can push back on the client instead of piling up
+goroutines, and after Stop, when a request still running past the shutdown
+timeout would otherwise send on the closed queue.
+*/
+func (p *Pool) Enqueue(job Job) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return false
+	}
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+//Stop closes the queue and waits for the workers to finish the jobs already queued, later calls only wait
+func (p *Pool) Stop() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		p.process(job)
+	}
+}
+
+//process handles one job in a background transaction
+func (p *Pool) process(job Job) {
+	txn := p.app.StartTransaction("worker-job")
+	defer txn.End()
+	txn.AddAttribute("job.id", job.ID)
+	txn.AddAttribute("job.payload_size", len(job.Payload))
+
+	func() {
+		defer txn.StartSegment("decode").End()
+		time.Sleep(5 * time.Millisecond)
+	}()
+	func() {
+		defer txn.StartSegment("handle").End()
+		time.Sleep(20 * time.Millisecond)
+	}()
+}
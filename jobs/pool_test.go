@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+
+	"NewRelics-POC/internal/nrtest"
+)
+
+func TestPool(t *testing.T) {
+	app, c := nrtest.NewApplication(t, "test")
+
+	//no workers, so the queue fills up
+	full := NewPool(app, 0, 1)
+	defer full.Stop()
+	if !full.Enqueue(Job{ID: "1"}) {
+		t.Error("Enqueue on an empty queue = false, want true")
+	}
+	if full.Enqueue(Job{ID: "2"}) {
+		t.Error("Enqueue on a full queue = true, want false")
+	}
+
+	p := NewPool(app, 2, 10)
+	for _, id := range []string{"a", "b", "c"} {
+		p.Enqueue(Job{ID: id})
+	}
+	p.Stop()
+	if p.Enqueue(Job{ID: "late"}) {
+		t.Error("Enqueue after Stop = true, want false")
+	}
+	p.Stop()
+	app.Shutdown(5 * time.Second)
+
+	events := c.Payload("analytic_event_data")
+	for _, id := range []string{"a", "b", "c"} {
+		if want := `"job.id":"` + id + `"`; !bytes.Contains(events, []byte(want)) {
+			t.Errorf("no worker-job transaction with %s: %s", want, events)
+		}
+	}
+	if bytes.Contains(events, []byte(`"job.id":"late"`)) {
+		t.Errorf("the job enqueued after Stop was processed: %s", events)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("test"),
+		newrelic.ConfigEnabled(false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := SelfTest(app, nil); len(id) != 32 {
+		t.Errorf("SelfTest() = %q, want a 32 character trace id", id)
+	}
+}
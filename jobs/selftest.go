@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+
+	"NewRelics-POC/telemetry"
+)
+
+/*
+SelfTest records a startup-selftest background transaction with one segment
+and a startup_selftest custom event, also sent to secondary when it is not
+nil, and returns the trace id to look it up by. Finding it in New Relic
+right after a deploy confirms data is flowing without waiting for real
+traffic.
+*/
+func SelfTest(app, secondary *newrelic.Application) string {
+	txn := app.StartTransaction("startup-selftest")
+	defer txn.End()
+
+	func() {
+		defer txn.StartSegment("selftest-work").End()
+		time.Sleep(5 * time.Millisecond)
+	}()
+	traceID := txn.GetTraceMetadata().TraceID
+	telemetry.RecordEvent("startup_selftest", map[string]interface{}{
+		"trace.id": traceID,
+		"time":     time.Now().UTC().Format(time.RFC3339),
+	}, app, secondary)
+	return traceID
+}
@@ -1,245 +1,184 @@
 package main
 
 import (
-	"errors"
-	"fmt"
-	"io"
-	"math/rand"
-	"net/http"
-	"os"
-	"sync"
+	"context"
+	"database/sql"
+	"flag"
+	"net"
 	"time"
 
-	"github.com/gin-gonic/gin"
-	nrgin "github.com/newrelic/go-agent/v3/integrations/nrgin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/newrelic/go-agent/v3/integrations/logcontext-v2/nrlogrus"
+	_ "github.com/newrelic/go-agent/v3/integrations/nrmysql"
+	"github.com/newrelic/go-agent/v3/integrations/nrpgx5"
+	nrredis "github.com/newrelic/go-agent/v3/integrations/nrredis-v9"
 	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	"NewRelics-POC/config"
+	"NewRelics-POC/grpcserver"
+	"NewRelics-POC/handlers"
+	"NewRelics-POC/jobs"
+	"NewRelics-POC/server"
+	"NewRelics-POC/telemetry"
 )
 
-//tranction example
-func EndpointAccessTransaction(c *gin.Context) {
-	txn := nrgin.Transaction(c)
-	txn.SetName("test-txn")
-	c.Writer.WriteString("test Transaction")
-}
-
-func index(c *gin.Context) {
-	io.WriteString(c.Writer, "hello world")
-}
-
-func versionHandler(c *gin.Context) {
-	io.WriteString(c.Writer, "New Relic Go Agent Version: "+newrelic.Version)
-}
-
-func noticeError(c *gin.Context) {
-	io.WriteString(c.Writer, "noticing an error")
+func main() {
+	logger := logrus.New()
 
-	if txn := newrelic.FromContext(c.Request.Context()); txn != nil {
-		txn.NoticeError(errors.New("my error message"))
+	port := flag.String("port", config.GetEnv("PORT", config.DefaultPort), "port to listen on")
+	flag.Parse()
+	cfg, err := config.Load(*port)
+	if nil != err {
+		logger.WithError(err).Fatal("invalid configuration")
 	}
-}
-
-//notice error with attributes
-func noticeErrorWithAttributes(c *gin.Context) {
-	io.WriteString(c.Writer, "noticing an error")
-	if txn := newrelic.FromContext(c.Request.Context()); txn != nil {
-		txn.NoticeError(newrelic.Error{
-			Message: "something went very wrong",
-			Class:   "errors are aggregated by class",
-			Attributes: map[string]interface{}{
-				"error no.": 97232,
-			},
-		})
+	logger.SetLevel(cfg.LogLevel)
+	var formatter logrus.Formatter = &logrus.TextFormatter{}
+	if cfg.LogFormat == config.LogFormatJSON {
+		formatter = &logrus.JSONFormatter{}
 	}
-}
-
-func customEvent(c *gin.Context) {
-	txn := newrelic.FromContext(c.Request.Context())
+	logger.SetFormatter(formatter)
 
-	io.WriteString(c.Writer, "recording a custom event")
-
-	if nil != txn {
-		txn.Application().RecordCustomEvent("my_event_type", map[string]interface{}{
-			"message": "hello world",
-			"Float":   0.603,
-			"Int":     123,
-			"Bool":    true,
-		})
+	logger.WithField("distributed_tracing", cfg.DistributedTracing).Info("distributed tracing setting")
+	if cfg.Host != "" {
+		logger.WithField("host", cfg.Host).Info("reporting to the New Relic collector host from NEW_RELIC_HOST")
 	}
-}
-
-func setName(c *gin.Context) {
-	io.WriteString(c.Writer, "changing the transaction's name")
-
-	if txn := newrelic.FromContext(c.Request.Context()); txn != nil {
-		txn.SetName("other-name")
+	if cfg.HighSecurity {
+		logger.Warn("New Relic High Security Mode is enabled, custom events and attributes may be dropped")
 	}
-}
-
-func addAttribute(c *gin.Context) {
-	io.WriteString(c.Writer, "adding attributes")
-
-	if txn := newrelic.FromContext(c.Request.Context()); txn != nil {
-		txn.AddAttribute("myString", "hello")
-		txn.AddAttribute("myInt", 123)
+	app, err := newrelic.NewApplication(config.NewRelicOptions(cfg)...)
+	if nil != err {
+		logger.WithError(err).Fatal("unable to start New Relic application")
 	}
-}
-
-func ignore(c *gin.Context) {
-	if coinFlip := (0 == rand.Intn(2)); coinFlip {
-		if txn := newrelic.FromContext(c.Request.Context()); txn != nil {
-			txn.Ignore()
+	//dual-report while moving accounts
+	var secondary *newrelic.Application
+	if cfg.SecondaryLicense != "" && cfg.Enabled {
+		secondary, err = newrelic.NewApplication(config.SecondaryOptions(cfg)...)
+		if nil != err {
+			logger.WithError(err).Fatal("unable to start the secondary New Relic application")
 		}
-		io.WriteString(c.Writer, "ignoring the transaction")
+		logger.Info("reporting to a secondary New Relic account")
+	}
+	if nrcfg, ok := app.Config(); ok {
+		logger.WithFields(logrus.Fields{
+			"max_txn_events":  nrcfg.TransactionEvents.MaxSamplesStored,
+			"max_span_events": nrcfg.DistributedTracer.ReservoirLimit,
+		}).Info("New Relic event sampling limits")
+	}
+	logger.WithFields(logrus.Fields{
+		"apdex_t":       cfg.ApdexThreshold,
+		"route_apdex_t": cfg.RouteApdex,
+	}).Info("Apdex thresholds for the apdex.zone attribute, the built-in Apdex uses the T set in the New Relic UI")
+	//decorate and forward log lines through the agent
+	logger.SetFormatter(nrlogrus.NewFormatter(app, formatter))
+
+	//the agent connects in the background, so an unreachable collector never blocks the server;
+	//a bad license key does not fail NewApplication either, so check the connection and keep serving
+	if !cfg.Enabled {
+		logger.Warn("New Relic is disabled by NEW_RELIC_ENABLED, nothing will be reported")
+	} else if err := app.WaitForConnection(cfg.ConnectTimeout); nil != err {
+		logger.WithError(err).Warn("New Relic agent is not connected, check the license key and network access")
 	} else {
-		io.WriteString(c.Writer, "not ignoring the transaction")
+		logger.Info("New Relic agent connected")
 	}
-}
-
-/*
-Segments are the specific parts of a transaction in an application.
-By instrumenting segments, you can measure the time taken by functions and code blocks,
-such as external calls, datastore calls, adding messages to queues, and background tasks.
-*/
-func segments(c *gin.Context) {
-	txn := newrelic.FromContext(c.Request.Context())
-
-	func() {
-		defer newrelic.StartSegment(txn, "f1").End()
-
-		func() {
-			defer newrelic.StartSegment(txn, "f2").End()
-
-			io.WriteString(c.Writer, "segments!")
-			time.Sleep(10 * time.Millisecond)
-		}()
-		time.Sleep(15 * time.Millisecond)
-	}()
-	time.Sleep(20 * time.Millisecond)
-}
-
-//add mesage in the segment
-func message(c *gin.Context) {
-	txn := newrelic.FromContext(c.Request.Context())
-	s := newrelic.MessageProducerSegment{
-		StartTime:       newrelic.StartSegmentNow(txn),
-		Library:         "Library",
-		DestinationType: newrelic.MessageQueue,
-		DestinationName: "Destination name",
+	//opt-in end-to-end check that data reaches New Relic
+	if cfg.RunSelfTest {
+		logger.WithField("trace.id", jobs.SelfTest(app, secondary)).Info("recorded the startup-selftest transaction")
 	}
-	defer s.End()
-
-	time.Sleep(20 * time.Millisecond)
-	io.WriteString(c.Writer, `producing a message queue message`)
-}
-
-//add transaction to external APIs request
-func external(c *gin.Context) {
-	txn := newrelic.FromContext(c.Request.Context())
-	req, _ := http.NewRequest("GET", "https://api.github.com/users/defunkt", nil)
-
-	es := newrelic.StartExternalSegment(txn, req)
-	resp, err := http.DefaultClient.Do(req)
-	es.End()
 
-	if err != nil {
-		io.WriteString(c.Writer, err.Error())
-		return
+	//in otel mode gin spans go through OpenTelemetry, the agent still handles logs and jobs
+	if cfg.TelemetryMode == config.TelemetryOTel {
+		shutdownOTel, err := telemetry.SetupOTel(context.Background(), cfg.AppName, cfg.License)
+		if nil != err {
+			logger.WithError(err).Fatal("unable to set up OpenTelemetry")
+		}
+		defer shutdownOTel(context.Background())
 	}
-	defer resp.Body.Close()
-	io.Copy(c.Writer, resp.Body)
-}
 
-//add transation to go routine.
-func async(c *gin.Context) {
-	txn := newrelic.FromContext(c.Request.Context())
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go func(txn *newrelic.Transaction) {
-		defer wg.Done()
-		defer newrelic.StartSegment(txn, "async").End()
-		time.Sleep(100 * time.Millisecond)
-	}(txn)
+	//open the database when a DSN is configured
+	var db *sql.DB
+	if cfg.DatabaseURL != "" {
+		db, err = sql.Open("nrmysql", cfg.DatabaseURL)
+		if nil != err {
+			logger.WithError(err).Fatal("unable to open database")
+		}
+		defer db.Close()
+	} else {
+		logger.Warn("DATABASE_URL is not set, /db/users is disabled")
+	}
 
-	segment := newrelic.StartSegment(txn, "wg.Wait")
-	wg.Wait()
-	segment.End()
-	c.Writer.Write([]byte("done!"))
-}
+	//connect to PostgreSQL when a connection string is configured
+	var pg *pgxpool.Pool
+	if cfg.PostgresURL != "" {
+		pgcfg, err := pgxpool.ParseConfig(cfg.PostgresURL)
+		if nil != err {
+			logger.WithError(err).Fatal("invalid POSTGRES_URL")
+		}
+		pgcfg.ConnConfig.Tracer = nrpgx5.NewTracer()
+		pg, err = pgxpool.NewWithConfig(context.Background(), pgcfg)
+		if nil != err {
+			logger.WithError(err).Fatal("unable to open postgres pool")
+		}
+		defer pg.Close()
+	} else {
+		logger.Warn("POSTGRES_URL is not set, /pg/time is disabled")
+	}
 
-/*
-This custom metric will have the name
-"Custom/HeaderLength" in the New Relic UI.
-*/
-func customMetric(c *gin.Context) {
-	txn := newrelic.FromContext(c.Request.Context())
-	for _, vals := range c.Request.Header {
-		for _, v := range vals {
-			if nil != txn {
-				txn.Application().RecordCustomMetric("HeaderLength", float64(len(v)))
-			}
+	//connect to Redis when an address is configured
+	var rdb *redis.Client
+	if cfg.RedisURL != "" {
+		opts, err := redis.ParseURL(cfg.RedisURL)
+		if nil != err {
+			logger.WithError(err).Fatal("invalid REDIS_URL")
 		}
+		rdb = redis.NewClient(opts)
+		rdb.AddHook(nrredis.NewHook(opts))
+		defer rdb.Close()
+	} else {
+		logger.Warn("REDIS_URL is not set, /cache/:key is disabled")
 	}
-	io.WriteString(c.Writer, "custom metric recorded")
-}
 
-/*
-BrowserTimingHeader() will always return a header whose methods can
-be safely called.
-*/
-func browser(c *gin.Context) {
-	txn := newrelic.FromContext(c.Request.Context())
-	hdr := txn.BrowserTimingHeader()
-	if js := hdr.WithTags(); js != nil {
-		c.Writer.Write(js)
+	//cron-style work outside of gin
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go jobs.RunNightly(jobCtx, app, time.Minute)
+	pool := jobs.NewPool(app, cfg.Workers, cfg.WorkerQueue)
+
+	//serve gRPC next to gin when a port is configured
+	var grpcServer *grpc.Server
+	if cfg.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", cfg.GRPCAddr)
+		if nil != err {
+			logger.WithError(err).Fatal("unable to listen for gRPC")
+		}
+		grpcServer = grpcserver.New(app)
+		go func() {
+			if err := grpcServer.Serve(lis); nil != err {
+				logger.WithError(err).Fatal("gRPC server error")
+			}
+		}()
+	} else {
+		logger.Warn("GRPC_PORT is not set, the gRPC server is disabled")
 	}
-	io.WriteString(c.Writer, "browser header page")
-}
-func main() {
-	app, err := newrelic.NewApplication(
-		//App name
-		newrelic.ConfigAppName("POC"),
-		//Private Key
-		newrelic.ConfigLicense("acb54af7704d14c310b831563bb78b855a01NRAL"),
-		newrelic.ConfigDistributedTracerEnabled(true),
-	)
-	if nil != err {
-		fmt.Println(err)
-		os.Exit(1)
+
+	h := handlers.New(app, logger, handlers.Deps{
+		Secondary: secondary,
+		DB:        db,
+		PG:        pg,
+		Redis:     rdb,
+		Config:    cfg,
+		Pool:      pool,
+	})
+	router := server.NewRouter(cfg, app, h)
+	//blocks until SIGINT/SIGTERM, then flush New Relic data
+	server.Run(cfg.Addr, router, cfg.ShutdownTimeout, logger)
+	if grpcServer != nil && !grpcserver.Stop(grpcServer, cfg.ShutdownTimeout) {
+		logger.Warn("gRPC calls still running after the shutdown timeout were cancelled")
 	}
-	router := gin.Default()
-	//define new relics middleware
-	router.Use(nrgin.Middleware(app))
-	//Example APIs
-	//set the transaction
-	router.GET("/txn", EndpointAccessTransaction)
-	//test the connection
-	router.GET("/test-connection", index)
-	//check the version of new relics being used
-	router.GET("/version", versionHandler)
-	//notice the error
-	router.GET("/notice_error", noticeError)
-	//test the error with attributes
-	router.GET("/notice_error_with_attributes", noticeErrorWithAttributes)
-	//add the custom events
-	router.GET("/custom_event", customEvent)
-	//set name for transaction
-	router.GET("/set_name", setName)
-	//add attribute to transaction
-	router.GET("/add_attribute", addAttribute)
-	//set which transation should get igored
-	router.GET("/ignore", ignore)
-	//add segment to the function
-	router.GET("/segments", segments)
-	//add transatio to external APIs
-	router.GET("/external", external)
-	//add metrics
-	router.GET("/custommetric", customMetric)
-	//browser recoard
-	router.GET("/browser", browser)
-	//transation in go routine
-	router.GET("/async", async)
-	//add mesage o the segment
-	router.GET("/message", message)
-	//running port
-	router.Run(":8000")
+	stopJobs()
+	pool.Stop()
+	app.Shutdown(cfg.ShutdownTimeout)
+	secondary.Shutdown(cfg.ShutdownTimeout)
 }
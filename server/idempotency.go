@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	nrgin "github.com/newrelic/go-agent/v3/integrations/nrgin"
+
+	"NewRelics-POC/handlers"
+)
+
+//IdempotencyKeyHeader carries the client's key for a retried mutating request
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+//cachedResponse is a response kept to be replayed for a repeated idempotency key
+type cachedResponse struct {
+	key         string
+	bodyHash    [sha256.Size]byte
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+/*
+idempotencyCache is an LRU of responses by idempotency key. It keeps at most
+size entries, evicting the least recently used one, and an entry older than
+ttl is treated as missing.
+*/
+type idempotencyCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newIdempotencyCache(size int, ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{size: size, ttl: ttl, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+//get returns the unexpired response cached under key and marks it as recently used
+func (c *idempotencyCache) get(key string, now time.Time) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	resp := elem.Value.(*cachedResponse)
+	if now.After(resp.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return resp, true
+}
+
+//put caches resp under its key, evicting the least recently used entry when full
+func (c *idempotencyCache) put(resp *cachedResponse, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp.expires = now.Add(c.ttl)
+	if elem, ok := c.entries[resp.key]; ok {
+		elem.Value = resp
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[resp.key] = c.order.PushFront(resp)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+//recordingWriter keeps a copy of everything written to the client
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+/*
+idempotency replays the cached response when a request repeats an
+Idempotency-Key seen within the cache's TTL, instead of running the handler
+again. Only the status, Content-Type and body are replayed, any other header
+the handler set is not. A repeat whose body differs from the first request's
+is refused with 422, the key was reused for another request. Replays bump the
+Custom/IdempotentReplay metric and are marked with replayed=true. Requests
+without the header always run, and 5xx responses are not cached so a retry
+after a server failure gets another attempt. Two concurrent requests with a
+new key both run, the later response wins.
+*/
+func idempotency(cache *idempotencyCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		key = c.Request.Method + " " + c.FullPath() + " " + key
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				//maxBody answers 413 once the chain returns
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, handlers.ErrorBody("BodyReadError", err.Error()))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		bodyHash := sha256.Sum256(body)
+
+		txn := nrgin.Transaction(c)
+		if resp, ok := cache.get(key, time.Now()); ok {
+			if resp.bodyHash != bodyHash {
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, handlers.ErrorBody("IdempotencyKeyReused", IdempotencyKeyHeader+" was already used for a request with a different body"))
+				return
+			}
+			txn.AddAttribute("replayed", true)
+			txn.Application().RecordCustomMetric("IdempotentReplay", 1)
+			c.Data(resp.status, resp.contentType, resp.body)
+			c.Abort()
+			return
+		}
+		txn.AddAttribute("replayed", false)
+
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		if w.Status() < http.StatusInternalServerError {
+			cache.put(&cachedResponse{key: key, bodyHash: bodyHash, status: w.Status(), contentType: w.Header().Get("Content-Type"), body: w.body.Bytes()}, time.Now())
+		}
+	}
+}
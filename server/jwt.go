@@ -0,0 +1,115 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	nrgin "github.com/newrelic/go-agent/v3/integrations/nrgin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+
+	"NewRelics-POC/handlers"
+)
+
+//jwtClaims are the claims of a bearer token used to attribute the transaction
+type jwtClaims struct {
+	Subject  string   `json:"sub"`
+	Roles    []string `json:"roles"`
+	TenantID string   `json:"tenant_id"`
+	Expires  int64    `json:"exp"`
+}
+
+//errors returned by parseJWT
+var (
+	errMalformedToken = errors.New("malformed token")
+	errAlgorithm      = errors.New("token is not signed with HS256")
+	errSignature      = errors.New("invalid token signature")
+	errExpired        = errors.New("token has expired")
+)
+
+//bearerToken returns the token of an Authorization header using the Bearer scheme, matched case-insensitively
+func bearerToken(header string) (string, bool) {
+	const scheme = "Bearer "
+	if len(header) < len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return "", false
+	}
+	return header[len(scheme):], true
+}
+
+//parseJWT verifies the HS256 signature and expiry of token and returns its claims
+func parseJWT(token string, secret []byte, now time.Time) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}, errMalformedToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if raw, err := base64.RawURLEncoding.DecodeString(parts[0]); err != nil || json.Unmarshal(raw, &header) != nil {
+		return jwtClaims{}, errMalformedToken
+	}
+	if header.Alg != "HS256" {
+		return jwtClaims{}, errAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return jwtClaims{}, errMalformedToken
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return jwtClaims{}, errSignature
+	}
+
+	var claims jwtClaims
+	if raw, err := base64.RawURLEncoding.DecodeString(parts[1]); err != nil || json.Unmarshal(raw, &claims) != nil {
+		return jwtClaims{}, errMalformedToken
+	}
+	if claims.Expires != 0 && now.Unix() >= claims.Expires {
+		return jwtClaims{}, errExpired
+	}
+	return claims, nil
+}
+
+/*
+jwtAuth verifies bearer tokens against secret and records their claims as
+enduser.id, enduser.roles and tenant.id, so transactions can be faceted by
+tenant and role. Requests without a bearer token go through anonymously; an
+invalid token is answered with 401 and noticed as an expected AuthError, a
+client mistake that should not move the error rate.
+*/
+func jwtAuth(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c.GetHeader("Authorization"))
+		if secret == "" || !ok {
+			c.Next()
+			return
+		}
+		txn := nrgin.Transaction(c)
+		claims, err := parseJWT(token, []byte(secret), time.Now())
+		if err != nil {
+			txn.NoticeExpectedError(newrelic.Error{Message: err.Error(), Class: "AuthError"})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, handlers.ErrorBody("AuthError", err.Error()))
+			return
+		}
+
+		if claims.Subject != "" {
+			txn.SetUserID(claims.Subject)
+			c.Set(handlers.UserIDKey, claims.Subject)
+		}
+		if len(claims.Roles) > 0 {
+			txn.AddAttribute("enduser.roles", strings.Join(claims.Roles, ","))
+		}
+		if claims.TenantID != "" {
+			txn.AddAttribute("tenant.id", claims.TenantID)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,18 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+//Prometheus counters scraped from /metrics, updated by captureAttributes
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests handled, by method, route and status.",
+	}, []string{"method", "route", "status"})
+	errorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_errors_total",
+		Help: "Number of HTTP requests answered with a 5xx status, by method and route.",
+	}, []string{"method", "route"})
+)
@@ -0,0 +1,627 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	nrgin "github.com/newrelic/go-agent/v3/integrations/nrgin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"NewRelics-POC/handlers"
+	"NewRelics-POC/telemetry"
+)
+
+/*
+captureAttributes records request and response details, including the
+client.ip resolved through the trusted proxies and the content type and size
+of the response, on every transaction and in the Prometheus counters. It
+also names the transaction after the matched route template, e.g.
+"GET /users/:id" rather than "GET /users/42", so parameterized routes do not
+explode the number of transaction names. Handlers can still rename the
+transaction afterwards.
+*/
+func captureAttributes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		txn := nrgin.Transaction(c)
+		if route := c.FullPath(); route != "" {
+			txn.SetName(c.Request.Method + " " + route)
+		}
+		c.Next()
+
+		txn.AddAttribute("request.method", c.Request.Method)
+		txn.AddAttribute("request.path", c.Request.URL.Path)
+		txn.AddAttribute("client.ip", c.ClientIP())
+		txn.AddAttribute("response.status", c.Writer.Status())
+		if contentType := c.Writer.Header().Get("Content-Type"); contentType != "" {
+			txn.AddAttribute("response.content_type", contentType)
+		}
+		//gin's writer counts the body bytes, -1 until something is written
+		txn.AddAttribute("response.size_bytes", max(c.Writer.Size(), 0))
+		txn.AddAttribute("response.duration_ms", float64(time.Since(start))/float64(time.Millisecond))
+
+		status := c.Writer.Status()
+		requestsTotal.WithLabelValues(c.Request.Method, c.FullPath(), strconv.Itoa(status)).Inc()
+		if status >= http.StatusInternalServerError {
+			errorsTotal.WithLabelValues(c.Request.Method, c.FullPath()).Inc()
+		}
+	}
+}
+
+/*
+transactionContext puts the nrgin transaction on the request context. nrgin
+v1.1.2 only stores it on the gin context and never on c.Request.Context(),
+so without this newrelic.FromContext returns nil in every handler: their
+segments, attributes and noticed errors, and those of the nrmysql, nrredis
+and outbound client instrumentation, are silently dropped. It has to run
+right after nrgin.Middleware, before anything reads the request context.
+*/
+func transactionContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if txn := nrgin.Transaction(c); txn != nil {
+			c.Request = newrelic.RequestWithTransactionContext(c.Request, txn)
+		}
+		c.Next()
+	}
+}
+
+/*
+requestLogger writes one line per request through logger, at warn level for
+4xx and error level for 5xx responses. The line carries the trace.id of the
+transaction, so it can be found from the trace in New Relic and the other
+way round.
+*/
+func requestLogger(logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		entry := logger.WithFields(logrus.Fields{
+			"method":      c.Request.Method,
+			"path":        c.Request.URL.Path,
+			"route":       c.FullPath(),
+			"status":      status,
+			"duration_ms": float64(time.Since(start)) / float64(time.Millisecond),
+			"client_ip":   c.ClientIP(),
+			"trace.id":    nrgin.Transaction(c).GetLinkingMetadata().TraceID,
+		})
+		switch {
+		case status >= http.StatusInternalServerError:
+			entry.Error("request")
+		case status >= http.StatusBadRequest:
+			entry.Warn("request")
+		default:
+			entry.Info("request")
+		}
+	}
+}
+
+/*
+slowRequests adds slow=true to transactions slower than threshold and records
+a slow_request custom event for them, so slow requests can be queried with
+NRQL without digging through transaction traces. The event goes to secondary
+as well when it is not nil.
+*/
+func slowRequests(threshold time.Duration, secondary *newrelic.Application) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		elapsed := time.Since(start)
+		if elapsed <= threshold {
+			return
+		}
+		txn := nrgin.Transaction(c)
+		txn.AddAttribute("slow", true)
+		telemetry.RecordEvent("slow_request", map[string]interface{}{
+			"method":      c.Request.Method,
+			"path":        c.Request.URL.Path,
+			"route":       c.FullPath(),
+			"status":      c.Writer.Status(),
+			"duration_ms": float64(elapsed) / float64(time.Millisecond),
+		}, txn.Application(), secondary)
+	}
+}
+
+//apdexZoneFor returns the Apdex zone of a request, S up to t, T up to 4t and F beyond it or on a 5xx
+func apdexZoneFor(elapsed, t time.Duration, status int) string {
+	switch {
+	case status >= http.StatusInternalServerError:
+		return "F"
+	case elapsed <= t:
+		return "S"
+	case elapsed <= 4*t:
+		return "T"
+	default:
+		return "F"
+	}
+}
+
+/*
+apdexZone scores every request against the Apdex T of its route, falling
+back to threshold, and records apdex.t_ms and apdex.zone on the transaction.
+The agent has no per-transaction Apdex setting outside serverless mode, the
+built-in Apdex uses the T set for the application in the New Relic UI, so
+per-route satisfaction is computed here and queried with NRQL, e.g.
+SELECT percentage(count(*), WHERE apdex.zone = 'S') FROM Transaction FACET name.
+*/
+func apdexZone(threshold time.Duration, routes map[string]time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		t := threshold
+		if override, ok := routes[c.FullPath()]; ok {
+			t = override
+		}
+		txn := nrgin.Transaction(c)
+		txn.AddAttribute("apdex.t_ms", t.Milliseconds())
+		txn.AddAttribute("apdex.zone", apdexZoneFor(time.Since(start), t, c.Writer.Status()))
+	}
+}
+
+/*
+bindingError turns a binding or validation error of c.Errors into a
+BindingError with one validation.<field> attribute per failed field holding
+the rule it broke, e.g. validation.Total=gt=0. It returns false for any
+other kind of error.
+*/
+func bindingError(e *gin.Error) (newrelic.Error, bool) {
+	var fields validator.ValidationErrors
+	if !errors.As(e.Err, &fields) && !e.IsType(gin.ErrorTypeBind) {
+		return newrelic.Error{}, false
+	}
+	attrs := map[string]interface{}{}
+	for _, f := range fields {
+		rule := f.Tag()
+		if f.Param() != "" {
+			rule += "=" + f.Param()
+		}
+		attrs["validation."+f.Field()] = rule
+	}
+	return newrelic.Error{Message: e.Error(), Class: "BindingError", Attributes: attrs}, true
+}
+
+/*
+bindingErrors notices every binding or validation error the handler added to
+c.Errors, so handlers only have to call c.Error, or use c.Bind which does it
+for them, instead of noticing each failure themselves.
+*/
+func bindingErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		txn := nrgin.Transaction(c)
+		for _, e := range c.Errors {
+			if nrErr, ok := bindingError(e); ok {
+				txn.NoticeError(nrErr)
+			}
+		}
+	}
+}
+
+//recoverWithNewRelic reports panics as errors on the transaction before answering 500
+func recoverWithNewRelic() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				txn := nrgin.Transaction(c)
+				txn.AddAttribute("stack", string(debug.Stack()))
+				txn.NoticeError(fmt.Errorf("panic: %v", r))
+				c.AbortWithStatus(http.StatusInternalServerError)
+			}
+		}()
+		c.Next()
+	}
+}
+
+//TransactionNameHeader renames the transaction when ALLOW_TXN_NAME_OVERRIDE is set
+const TransactionNameHeader = "X-Transaction-Name"
+
+/*
+transactionName renames the transaction to the X-Transaction-Name header, to
+group synthetic or canary traffic apart from the rest. Every distinct value
+becomes a transaction name, so clients could explode the cardinality: the
+header is ignored unless allowed, which only ALLOW_TXN_NAME_OVERRIDE sets.
+It runs after captureAttributes so it wins over the route name.
+*/
+func transactionName(allowed bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if name := strings.TrimSpace(c.GetHeader(TransactionNameHeader)); allowed && name != "" {
+			txn := nrgin.Transaction(c)
+			txn.SetName(name)
+			txn.AddAttribute("name_override", true)
+		}
+		c.Next()
+	}
+}
+
+//inFlight counts the requests being served, Run logs it when shutting down
+var inFlight atomic.Int64
+
+/*
+inFlightRequests counts the request in counter while it is served and
+records the count including it as the Custom/InFlightRequests metric, so
+concurrency and the drain of a deploy can be charted.
+*/
+func inFlightRequests(counter *atomic.Int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		n := counter.Add(1)
+		defer counter.Add(-1)
+		nrgin.Transaction(c).Application().RecordCustomMetric("InFlightRequests", float64(n))
+		c.Next()
+	}
+}
+
+//environment adds the deployment environment to every transaction, so NRQL can filter on it
+func environment(env string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nrgin.Transaction(c).AddAttribute("environment", env)
+		c.Next()
+	}
+}
+
+//countryHeaders carry the client's country as set by a CDN, the first one present wins
+var countryHeaders = []string{"CF-IPCountry", "CloudFront-Viewer-Country", "X-Country-Code"}
+
+//requestLocale is the preferred language of an Accept-Language header, e.g. "de-CH" for "de-CH,de;q=0.9"
+func requestLocale(acceptLanguage string) string {
+	tag, _, _ := strings.Cut(acceptLanguage, ",")
+	tag, _, _ = strings.Cut(tag, ";")
+	if tag = strings.TrimSpace(tag); tag == "" || tag == "*" {
+		return "unknown"
+	}
+	return tag
+}
+
+//requestCountry is the upper-cased country code from the first country header set, Cloudflare's XX means unknown
+func requestCountry(header http.Header) string {
+	for _, key := range countryHeaders {
+		if country := strings.ToUpper(strings.TrimSpace(header.Get(key))); country != "" {
+			if country == "XX" {
+				break
+			}
+			return country
+		}
+	}
+	return "unknown"
+}
+
+//locale adds the request.locale and request.country attributes for faceting traffic by region
+func locale() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		txn := nrgin.Transaction(c)
+		txn.AddAttribute("request.locale", requestLocale(c.GetHeader("Accept-Language")))
+		txn.AddAttribute("request.country", requestCountry(c.Request.Header))
+		c.Next()
+	}
+}
+
+/*
+routeParams adds every path parameter of the matched route as a route.<name>
+attribute, e.g. route.id=42 for /users/:id, so transactions can be faceted by
+them without code in each handler. gin has matched the route before any
+middleware runs, so the parameters are already set.
+*/
+func routeParams() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		txn := nrgin.Transaction(c)
+		for _, p := range c.Params {
+			txn.AddAttribute("route."+p.Key, p.Value)
+		}
+		c.Next()
+	}
+}
+
+/*
+ignorePaths ignores the transaction of any request whose path starts with
+one of the lower-cased prefixes, compared case-insensitively. Handlers can
+check handlers.IgnoredKey on the context to see whether the request was dropped.
+*/
+func ignorePaths(prefixes []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := strings.ToLower(c.Request.URL.Path)
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(path, prefix) {
+				nrgin.Transaction(c).Ignore()
+				c.Set(handlers.IgnoredKey, true)
+				break
+			}
+		}
+		c.Next()
+	}
+}
+
+/*
+ignoreTransaction drops the transaction of every request it sees. It is
+used as group middleware, so everything registered under the group, such as
+static assets, is never reported while the rest of the middleware still runs.
+*/
+func ignoreTransaction() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nrgin.Transaction(c).Ignore()
+		c.Set(handlers.IgnoredKey, true)
+		c.Next()
+	}
+}
+
+//errorBodyWriter keeps up to limit bytes of the body once the status is >= 400
+type errorBodyWriter struct {
+	gin.ResponseWriter
+	limit int
+	body  bytes.Buffer
+}
+
+func (w *errorBodyWriter) capture(b []byte) {
+	if w.Status() < http.StatusBadRequest {
+		return
+	}
+	if remaining := w.limit - w.body.Len(); remaining > 0 {
+		if len(b) > remaining {
+			b = b[:remaining]
+		}
+		w.body.Write(b)
+	}
+}
+
+func (w *errorBodyWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorBodyWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+/*
+captureErrorBody records the first limit bytes of error responses as the
+response.error_body attribute. Every write still goes straight through to
+the client, so streamed responses such as /external are not buffered.
+*/
+func captureErrorBody(limit int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+		w := &errorBodyWriter{ResponseWriter: c.Writer, limit: limit}
+		c.Writer = w
+		c.Next()
+
+		if w.body.Len() > 0 {
+			nrgin.Transaction(c).AddAttribute("response.error_body", w.body.String())
+		}
+	}
+}
+
+/*
+requestID makes sure every request carries an X-Request-ID, generating one
+when the client did not send it. The id is echoed on the response, stored
+under handlers.RequestIDKey and added to the transaction so traces and log
+lines can be joined on it.
+*/
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if id == "" {
+			id = uuid.NewString()
+		}
+		c.Header("X-Request-ID", id)
+		c.Set(handlers.RequestIDKey, id)
+		nrgin.Transaction(c).AddAttribute("request.id", id)
+		c.Next()
+	}
+}
+
+/*
+rateLimit rejects requests with 429 once limiter runs out of tokens. Each
+throttled request bumps the Custom/Throttled metric and is marked with
+rate_limited=true, so throttling can be charted in New Relic.
+*/
+func rateLimit(limiter *rate.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !limiter.Allow() {
+			txn := nrgin.Transaction(c)
+			txn.AddAttribute("rate_limited", true)
+			txn.Application().RecordCustomMetric("Throttled", 1)
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, handlers.ErrorBody("RateLimited", "rate limit exceeded, retry later"))
+			return
+		}
+		c.Next()
+	}
+}
+
+//limitedBody remembers whether the handler read past the limit of the MaxBytesReader it wraps
+type limitedBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+/*
+maxBody caps request bodies at limit bytes, except on the exempt routes which
+apply a limit of their own. A body announced as larger is refused with 413
+up front; one that only turns out larger while the handler reads it gets the
+413 when the handler has not answered yet. Either way an oversized_request
+custom event, sent to secondary too when it is not nil, records the path.
+*/
+func maxBody(limit int64, secondary *newrelic.Application, exempt ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, route := range exempt {
+			if c.FullPath() == route {
+				c.Next()
+				return
+			}
+		}
+		tooLarge := func() {
+			telemetry.RecordEvent("oversized_request", map[string]interface{}{
+				"method": c.Request.Method,
+				"path":   c.Request.URL.Path,
+				"limit":  limit,
+			}, nrgin.Transaction(c).Application(), secondary)
+			if !c.Writer.Written() {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, handlers.ErrorBody("RequestTooLarge", fmt.Sprintf("request body must not exceed %d bytes", limit)))
+			}
+		}
+		if c.Request.ContentLength > limit {
+			tooLarge()
+			return
+		}
+
+		body := &limitedBody{ReadCloser: http.MaxBytesReader(c.Writer, c.Request.Body, limit)}
+		c.Request.Body = body
+		c.Next()
+		if body.exceeded {
+			tooLarge()
+		}
+	}
+}
+
+/*
+requestTimeout puts a deadline of d on the request context, which cancels
+outbound calls and queries made with it. Handlers are expected to return once
+the context is done; when the deadline passed, the transaction is marked with
+timed_out=true, the timeout is noticed and, if nothing was written yet, the
+client gets a 504.
+*/
+func requestTimeout(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return
+		}
+		txn := nrgin.Transaction(c)
+		txn.AddAttribute("timed_out", true)
+		failure := newrelic.Error{
+			Message: fmt.Sprintf("request exceeded the %s timeout", d),
+			Class:   "RequestTimeout",
+		}
+		txn.NoticeError(failure)
+		if !c.Writer.Written() {
+			c.AbortWithStatusJSON(http.StatusGatewayTimeout, handlers.ErrorBody(failure.Class, failure.Message))
+		}
+	}
+}
+
+/*
+bearerSubject returns the sub claim of a JWT bearer token. The signature is
+not checked, the claim is only used to attribute the request.
+*/
+func bearerSubject(header string) string {
+	token, ok := bearerToken(header)
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if json.Unmarshal(payload, &claims) != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+/*
+userID attributes the transaction to the calling user, read from X-User-ID
+or else from the sub claim of a bearer token. SetUserID records it as the
+enduser.id attribute, which the New Relic UI offers as the user facet.
+Requests without a user id go through anonymously.
+
+When tokensVerified, jwtAuth runs first and has already recorded the user of
+a valid token, and only that verified identity is trusted: the unverified sub
+claim is never read, and X-User-ID is kept as the claimed_user_id attribute
+without attributing the request, so neither a forged token nor a header can
+impersonate a user.
+*/
+func userID(tokensVerified bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tokensVerified {
+			if claimed := c.GetHeader("X-User-ID"); claimed != "" {
+				nrgin.Transaction(c).AddAttribute("claimed_user_id", claimed)
+			}
+			c.Next()
+			return
+		}
+		if c.GetString(handlers.UserIDKey) != "" {
+			c.Next()
+			return
+		}
+		id := c.GetHeader("X-User-ID")
+		if id == "" {
+			id = bearerSubject(c.GetHeader("Authorization"))
+		}
+		if id != "" {
+			nrgin.Transaction(c).SetUserID(id)
+			c.Set(handlers.UserIDKey, id)
+		}
+		c.Next()
+	}
+}
+
+/*
+secondaryTransaction mirrors every request as a transaction of a second
+application, so both accounts see the traffic during a migration. The
+transaction takes the name the primary one ends up with, or NotFound when
+no route matched. Segments and attributes stay on the primary transaction.
+*/
+func secondaryTransaction(app *newrelic.Application) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if app == nil {
+			c.Next()
+			return
+		}
+		//an unmatched request has no route, naming it "GET " would file every 404 under one blank name
+		route := c.FullPath()
+		name := "NotFound"
+		if route != "" {
+			name = c.Request.Method + " " + route
+		}
+		txn := app.StartTransaction(name)
+		txn.SetWebRequestHTTP(c.Request)
+		c.Next()
+
+		if primary := nrgin.Transaction(c).Name(); route != "" && primary != "" {
+			txn.SetName(primary)
+		}
+		txn.SetWebResponse(nil).WriteHeader(c.Writer.Status())
+		txn.End()
+	}
+}
@@ -0,0 +1,775 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	nrgin "github.com/newrelic/go-agent/v3/integrations/nrgin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"NewRelics-POC/config"
+	"NewRelics-POC/handlers"
+	"NewRelics-POC/internal/nrtest"
+)
+
+//newTestRouter returns a gin engine behind the New Relic middleware of a disabled agent
+func newTestRouter(t *testing.T, middleware ...gin.HandlerFunc) *gin.Engine {
+	t.Helper()
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("test"),
+		newrelic.ConfigEnabled(false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(nrgin.Middleware(app))
+	router.Use(middleware...)
+	return router
+}
+
+/*
+newCollectorRouter is newTestRouter behind an application reporting to a
+fake collector, for tests that assert on what the middleware records. Call
+app.Shutdown before reading the collector.
+*/
+func newCollectorRouter(t *testing.T, middleware ...gin.HandlerFunc) (*gin.Engine, *newrelic.Application, *nrtest.Collector) {
+	t.Helper()
+	app, c := nrtest.NewApplication(t, "test")
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(nrgin.Middleware(app))
+	router.Use(middleware...)
+	return router, app, c
+}
+
+func TestIgnorePaths(t *testing.T) {
+	router := newTestRouter(t, ignorePaths([]string{"/ignore", "/static"}))
+	router.GET("/*path", func(c *gin.Context) {
+		if c.GetBool(handlers.IgnoredKey) {
+			c.String(http.StatusOK, "ignored")
+			return
+		}
+		c.String(http.StatusOK, "reported")
+	})
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/ignore", "ignored"},
+		{"/IGNORE/me", "ignored"},
+		{"/static/app.js", "ignored"},
+		{"/segments", "reported"},
+		{"/not/ignore", "reported"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestErrorBodyWriter(t *testing.T) {
+	var captured string
+	router := newTestRouter(t, func(c *gin.Context) {
+		w := &errorBodyWriter{ResponseWriter: c.Writer, limit: 5}
+		c.Writer = w
+		c.Next()
+		captured = w.body.String()
+	})
+	router.GET("/ok", func(c *gin.Context) { c.String(http.StatusOK, "all good") })
+	router.GET("/fail", func(c *gin.Context) { c.String(http.StatusBadGateway, "upstream failed") })
+
+	tests := []struct {
+		path     string
+		wantBody string
+		wantKept string
+	}{
+		{"/ok", "all good", ""},
+		{"/fail", "upstream failed", "upstr"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Body.String() != tt.wantBody {
+			t.Errorf("%s: body = %q, want %q", tt.path, rec.Body.String(), tt.wantBody)
+		}
+		if captured != tt.wantKept {
+			t.Errorf("%s: captured = %q, want %q", tt.path, captured, tt.wantKept)
+		}
+	}
+}
+
+func TestSlowRequests(t *testing.T) {
+	router, app, c := newCollectorRouter(t, slowRequests(20*time.Millisecond, nil))
+	router.GET("/fast", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/slow", func(c *gin.Context) {
+		time.Sleep(40 * time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+
+	for _, path := range []string{"/fast", "/slow"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	app.Shutdown(5 * time.Second)
+
+	events := c.Payload("custom_event_data")
+	if got := bytes.Count(events, []byte(`"type":"slow_request"`)); got != 1 {
+		t.Errorf("recorded %d slow_request events, want 1: %s", got, events)
+	}
+	if !bytes.Contains(events, []byte(`"route":"/slow"`)) || bytes.Contains(events, []byte(`"route":"/fast"`)) {
+		t.Errorf("want only the slow request recorded: %s", events)
+	}
+	if want := `"slow":true`; bytes.Count(c.Payload("analytic_event_data"), []byte(want)) != 1 {
+		t.Errorf("want exactly the slow transaction to carry %s", want)
+	}
+}
+
+func TestRouteParams(t *testing.T) {
+	router, app, c := newCollectorRouter(t, routeParams())
+	router.GET("/categories/:category/items/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/categories/books/items/7", nil))
+	app.Shutdown(5 * time.Second)
+
+	events := c.Payload("analytic_event_data")
+	for _, want := range []string{`"route.category":"books"`, `"route.id":"7"`} {
+		if !bytes.Contains(events, []byte(want)) {
+			t.Errorf("the transaction lacks %s: %s", want, events)
+		}
+	}
+}
+
+func TestCaptureErrorBody(t *testing.T) {
+	router, app, c := newCollectorRouter(t, captureErrorBody(5))
+	router.GET("/ok", func(c *gin.Context) { c.String(http.StatusOK, "all good") })
+	router.GET("/fail", func(c *gin.Context) { c.String(http.StatusBadGateway, "upstream failed") })
+
+	for _, path := range []string{"/ok", "/fail"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	app.Shutdown(5 * time.Second)
+
+	events := c.Payload("analytic_event_data")
+	if want := `"response.error_body":"upstr"`; bytes.Count(events, []byte(want)) != 1 {
+		t.Errorf("want exactly the failed request to carry %s, got %s", want, events)
+	}
+	if bytes.Contains(events, []byte("all good")) {
+		t.Errorf("the successful response body was recorded: %s", events)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	router := newTestRouter(t, requestID())
+	router.GET("/id", func(c *gin.Context) { c.String(http.StatusOK, c.GetString(handlers.RequestIDKey)) })
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/id", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	router.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); got != "abc-123" {
+		t.Errorf("response header = %q, want %q", got, "abc-123")
+	}
+	if got := rec.Body.String(); got != "abc-123" {
+		t.Errorf("context id = %q, want %q", got, "abc-123")
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/id", nil))
+	generated := rec.Header().Get("X-Request-ID")
+	if generated == "" || generated != rec.Body.String() {
+		t.Errorf("generated id = %q, context id = %q", generated, rec.Body.String())
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	router, app, c := newCollectorRouter(t, rateLimit(rate.NewLimiter(rate.Limit(1), 2)))
+	router.GET("/limited", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	tests := []struct {
+		wantStatus int
+		wantBody   string
+	}{
+		{http.StatusOK, "ok"},
+		{http.StatusOK, "ok"},
+		{http.StatusTooManyRequests, `{"error":{"class":"RateLimited","message":"rate limit exceeded, retry later"}}`},
+	}
+	for i, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/limited", nil))
+		if rec.Code != tt.wantStatus || rec.Body.String() != tt.wantBody {
+			t.Errorf("request %d: got %d %q, want %d %q", i, rec.Code, rec.Body.String(), tt.wantStatus, tt.wantBody)
+		}
+	}
+	app.Shutdown(5 * time.Second)
+
+	if !c.MetricNames()["Custom/Throttled"] {
+		t.Errorf("no Custom/Throttled metric: %v", c.MetricNames())
+	}
+	if want := `"rate_limited":true`; bytes.Count(c.Payload("analytic_event_data"), []byte(want)) != 1 {
+		t.Errorf("want exactly the throttled transaction to carry %s", want)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	h := handlers.New(nil, logger, handlers.Deps{})
+	router := newTestRouter(t, requestTimeout(20*time.Millisecond))
+	router.GET("/slow", h.Slow)
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"/slow?ms=1", http.StatusOK, ""},
+		{"/slow?ms=1000", http.StatusGatewayTimeout, `{"error":{"class":"RequestTimeout","message":"request exceeded the 20ms timeout"}}`},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.path, rec.Code, tt.wantStatus)
+		}
+		if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+			t.Errorf("%s: body = %q, want %q", tt.path, rec.Body.String(), tt.wantBody)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("%s: took %s, want the handler cancelled by the timeout", tt.path, elapsed)
+		}
+	}
+}
+
+func TestUserID(t *testing.T) {
+	router := newTestRouter(t, userID(false))
+	router.GET("/user", func(c *gin.Context) { c.String(http.StatusOK, c.GetString(handlers.UserIDKey)) })
+
+	token := "Bearer eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-7"}`)) + ".sig"
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   string
+	}{
+		{"header", "X-User-ID", "user-42", "user-42"},
+		{"bearer token", "Authorization", token, "user-7"},
+		{"lower-case scheme", "Authorization", "bearer" + strings.TrimPrefix(token, "Bearer"), "user-7"},
+		{"malformed token", "Authorization", "Bearer nope", ""},
+		{"anonymous", "", "", ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/user", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		router.ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("%s: user id = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTransactionContext(t *testing.T) {
+	router := newTestRouter(t, transactionContext())
+	router.GET("/txn", func(c *gin.Context) {
+		if newrelic.FromContext(c.Request.Context()) != nrgin.Transaction(c) {
+			c.String(http.StatusOK, "missing")
+			return
+		}
+		c.String(http.StatusOK, "found")
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/txn", nil))
+	if got := rec.Body.String(); got != "found" {
+		t.Errorf("got %q, want %q", got, "found")
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	router := newTestRouter(t, requestLogger(logger))
+	router.GET("/missing", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("log line %q is not JSON: %v", out.String(), err)
+	}
+	for key, want := range map[string]interface{}{"level": "warning", "method": "GET", "path": "/missing", "status": float64(http.StatusNotFound)} {
+		if line[key] != want {
+			t.Errorf("%s = %v, want %v", key, line[key], want)
+		}
+	}
+	if _, ok := line["trace.id"]; !ok {
+		t.Errorf("log line %q has no trace.id", out.String())
+	}
+}
+
+func TestApdexZoneFor(t *testing.T) {
+	tests := []struct {
+		elapsed time.Duration
+		status  int
+		want    string
+	}{
+		{100 * time.Millisecond, http.StatusOK, "S"},
+		{500 * time.Millisecond, http.StatusOK, "S"},
+		{2 * time.Second, http.StatusOK, "T"},
+		{3 * time.Second, http.StatusNotFound, "F"},
+		{time.Millisecond, http.StatusInternalServerError, "F"},
+	}
+	for _, tt := range tests {
+		if got := apdexZoneFor(tt.elapsed, 500*time.Millisecond, tt.status); got != tt.want {
+			t.Errorf("apdexZoneFor(%s, %d) = %q, want %q", tt.elapsed, tt.status, got, tt.want)
+		}
+	}
+}
+
+//signJWT returns an HS256 token carrying claims
+func signJWT(t *testing.T, secret string, claims interface{}) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTAuth(t *testing.T) {
+	router := newTestRouter(t, jwtAuth("secret"))
+	router.GET("/user", func(c *gin.Context) { c.String(http.StatusOK, c.GetString(handlers.UserIDKey)) })
+
+	claims := map[string]interface{}{"sub": "user-7", "roles": []string{"admin"}, "tenant_id": "acme"}
+	expired := map[string]interface{}{"sub": "user-7", "exp": time.Now().Add(-time.Minute).Unix()}
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+		wantBody   string
+	}{
+		{"anonymous", "", http.StatusOK, ""},
+		{"valid", "Bearer " + signJWT(t, "secret", claims), http.StatusOK, "user-7"},
+		{"wrong secret", "Bearer " + signJWT(t, "other", claims), http.StatusUnauthorized, "invalid token signature"},
+		{"expired", "Bearer " + signJWT(t, "secret", expired), http.StatusUnauthorized, "token has expired"},
+		{"malformed", "Bearer nope", http.StatusUnauthorized, "malformed token"},
+		{"lower-case scheme", "bearer " + signJWT(t, "secret", claims), http.StatusOK, "user-7"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/user", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+		if !strings.Contains(rec.Body.String(), tt.wantBody) {
+			t.Errorf("%s: body = %q, want it to contain %q", tt.name, rec.Body.String(), tt.wantBody)
+		}
+	}
+}
+
+func TestUserIDVerified(t *testing.T) {
+	var user string
+	router, app, c := newCollectorRouter(t, jwtAuth("secret"), userID(true))
+	router.GET("/user", func(c *gin.Context) { user = c.GetString(handlers.UserIDKey) })
+
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		wantStatus int
+		wantUser   string
+	}{
+		{"forged token", map[string]string{"Authorization": "Bearer " + signJWT(t, "other", map[string]interface{}{"sub": "admin"})}, http.StatusUnauthorized, ""},
+		{"verified token wins over the header", map[string]string{"Authorization": "Bearer " + signJWT(t, "secret", map[string]interface{}{"sub": "user-7"}), "X-User-ID": "user-42"}, http.StatusOK, "user-7"},
+		{"header only is not trusted", map[string]string{"X-User-ID": "user-43"}, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		user = ""
+		req := httptest.NewRequest("GET", "/user", nil)
+		for key, value := range tt.headers {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus || user != tt.wantUser {
+			t.Errorf("%s: status, user = %d, %q, want %d, %q", tt.name, rec.Code, user, tt.wantStatus, tt.wantUser)
+		}
+	}
+	app.Shutdown(5 * time.Second)
+
+	events := c.Payload("analytic_event_data")
+	if want := `"claimed_user_id":"user-43"`; !bytes.Contains(events, []byte(want)) {
+		t.Errorf("the unverified header was not kept as %s: %s", want, events)
+	}
+	if !bytes.Contains(events, []byte(`"enduser.id":"user-7"`)) || bytes.Contains(events, []byte(`"enduser.id":"user-4`)) {
+		t.Errorf("want only the verified user-7 as enduser.id: %s", events)
+	}
+}
+
+func TestMaxBody(t *testing.T) {
+	router := newTestRouter(t, maxBody(10, nil, "/upload"))
+	read := func(c *gin.Context) {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			return
+		}
+		c.String(http.StatusOK, "read")
+	}
+	router.POST("/echo", read)
+	router.POST("/upload", read)
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		chunked    bool
+		wantStatus int
+	}{
+		{"small", "/echo", "hello", false, http.StatusOK},
+		{"announced too large", "/echo", "hello world!", false, http.StatusRequestEntityTooLarge},
+		{"chunked too large", "/echo", "hello world!", true, http.StatusRequestEntityTooLarge},
+		{"exempt", "/upload", "hello world!", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+		if tt.chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestBindingError(t *testing.T) {
+	var order struct {
+		ID    string  `json:"id" binding:"required"`
+		Total float64 `json:"total" binding:"required,gt=0"`
+	}
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/orders", strings.NewReader(`{"total":-1}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	invalid := c.Error(c.ShouldBindJSON(&order))
+
+	nrErr, ok := bindingError(invalid)
+	if !ok || nrErr.Class != "BindingError" {
+		t.Fatalf("bindingError() = %+v, %v, want a BindingError", nrErr, ok)
+	}
+	for key, want := range map[string]interface{}{"validation.ID": "required", "validation.Total": "gt=0"} {
+		if got := nrErr.Attributes[key]; got != want {
+			t.Errorf("Attributes[%q] = %v, want %v", key, got, want)
+		}
+	}
+
+	malformed := c.Error(errors.New("unexpected EOF")).SetType(gin.ErrorTypeBind)
+	if _, ok := bindingError(malformed); !ok {
+		t.Error("bindingError() of a bind error = false, want true")
+	}
+	if _, ok := bindingError(c.Error(errors.New("boom"))); ok {
+		t.Error("bindingError() of a private error = true, want false")
+	}
+}
+
+func TestStaticAssets(t *testing.T) {
+	cfg, err := config.Load(config.DefaultPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	app, err := newrelic.NewApplication(newrelic.ConfigAppName("test"), newrelic.ConfigEnabled(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter(cfg, app, handlers.New(app, logger, handlers.Deps{}))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/static/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<h1>NewRelics-POC</h1>") {
+		t.Errorf("GET /static/ = %d %q, want the embedded page", rec.Code, rec.Body.String())
+	}
+
+	var ignored bool
+	router = newTestRouter(t)
+	router.Group("/static", ignoreTransaction()).GET("/*filepath", func(c *gin.Context) { ignored = c.GetBool(handlers.IgnoredKey) })
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/static/style.css", nil))
+	if !ignored {
+		t.Error("a request under the static group was not ignored")
+	}
+}
+
+func TestRouteFilter(t *testing.T) {
+	router := newTestRouter(t)
+	routes := newRouteFilter(router, []string{"/panic", "/users/:id", "PUT /cache/:key", "/typo"})
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	routes.GET("/panic", ok)
+	routes.GET("/users/:id", ok)
+	routes.GET("/users", ok)
+	routes.POST("/orders", ok)
+	routes.GET("/cache/:key", ok)
+	routes.PUT("/cache/:key", ok)
+
+	for _, tt := range []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/panic", http.StatusNotFound},
+		{"GET", "/users/42", http.StatusNotFound},
+		{"GET", "/users", http.StatusOK},
+		{"POST", "/orders", http.StatusOK},
+		{"GET", "/cache/a", http.StatusOK},
+		{"PUT", "/cache/a", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+	}
+	if got := routes.unmatched(); !reflect.DeepEqual(got, []string{"/typo"}) {
+		t.Errorf("unmatched() = %q, want %q", got, []string{"/typo"})
+	}
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	now := time.Now()
+	cache := newIdempotencyCache(2, time.Minute)
+	cache.put(&cachedResponse{key: "a"}, now)
+	cache.put(&cachedResponse{key: "b"}, now)
+	cache.get("a", now)
+	cache.put(&cachedResponse{key: "c"}, now)
+
+	if _, ok := cache.get("b", now); ok {
+		t.Error("the least recently used entry was not evicted")
+	}
+	if _, ok := cache.get("a", now); !ok {
+		t.Error("a recently used entry was evicted")
+	}
+	if _, ok := cache.get("c", now.Add(2*time.Minute)); ok {
+		t.Error("an expired entry was returned")
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	var runs int
+	router, app, collector := newCollectorRouter(t)
+	router.POST("/orders", idempotency(newIdempotencyCache(10, time.Minute)), func(c *gin.Context) {
+		runs++
+		if c.Query("fail") != "" {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(c.Request.Body)
+		c.JSON(http.StatusCreated, gin.H{"run": runs, "body": string(body)})
+	})
+	post := func(key, query, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/orders"+query, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := post("k1", "", `{"item":1}`)
+	if want := `{"body":"{\"item\":1}","run":1}`; first.Body.String() != want {
+		t.Errorf("first body = %q, want the handler to read the request body: %q", first.Body.String(), want)
+	}
+	replay := post("k1", "", `{"item":1}`)
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() || runs != 1 {
+		t.Errorf("replay = %d %q after %d runs, want %d %q after 1", replay.Code, replay.Body.String(), runs, first.Code, first.Body.String())
+	}
+	if got := replay.Header().Get("Content-Type"); got != first.Header().Get("Content-Type") {
+		t.Errorf("replayed Content-Type = %q, want %q", got, first.Header().Get("Content-Type"))
+	}
+	if reused := post("k1", "", `{"item":2}`); reused.Code != http.StatusUnprocessableEntity || runs != 1 {
+		t.Errorf("reused key with another body = %d after %d runs, want %d after 1", reused.Code, runs, http.StatusUnprocessableEntity)
+	}
+
+	post("", "", "")
+	post("", "", "")
+	post("k2", "?fail=1", "")
+	post("k2", "?fail=1", "")
+	if runs != 5 {
+		t.Errorf("handler ran %d times, want requests without a key and failed ones to run again", runs)
+	}
+
+	app.Shutdown(5 * time.Second)
+	if !collector.MetricNames()["Custom/IdempotentReplay"] {
+		t.Errorf("no Custom/IdempotentReplay metric: %v", collector.MetricNames())
+	}
+	events := collector.Payload("analytic_event_data")
+	if got := bytes.Count(events, []byte(`"replayed":true`)); got != 1 {
+		t.Errorf("%d transactions marked replayed=true, want 1: %s", got, events)
+	}
+	if got := bytes.Count(events, []byte(`"replayed":false`)); got != 3 {
+		t.Errorf("%d transactions marked replayed=false, want 3: %s", got, events)
+	}
+}
+
+func TestInFlightRequests(t *testing.T) {
+	var counter atomic.Int64
+	var during int64
+	router := newTestRouter(t, inFlightRequests(&counter))
+	router.GET("/work", func(c *gin.Context) { during = counter.Load() })
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/work", nil))
+	if during != 1 {
+		t.Errorf("in flight during the request = %d, want 1", during)
+	}
+	if got := counter.Load(); got != 0 {
+		t.Errorf("in flight after the request = %d, want 0", got)
+	}
+}
+
+func TestTransactionName(t *testing.T) {
+	for _, allowed := range []bool{true, false} {
+		router := newTestRouter(t, captureAttributes(), transactionName(allowed))
+		router.GET("/users/:id", func(c *gin.Context) { c.String(http.StatusOK, nrgin.Transaction(c).Name()) })
+
+		req := httptest.NewRequest("GET", "/users/42", nil)
+		req.Header.Set(TransactionNameHeader, "canary checkout")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		want := "GET /users/:id"
+		if allowed {
+			want = "canary checkout"
+		}
+		if got := rec.Body.String(); got != want {
+			t.Errorf("allowed=%v: transaction name = %q, want %q", allowed, got, want)
+		}
+	}
+}
+
+func TestRequestLocale(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"", "unknown"},
+		{"*", "unknown"},
+		{"en", "en"},
+		{"de-CH,de;q=0.9,en;q=0.8", "de-CH"},
+		{" fr;q=0.7 ", "fr"},
+	}
+	for _, tt := range tests {
+		if got := requestLocale(tt.acceptLanguage); got != tt.want {
+			t.Errorf("requestLocale(%q) = %q, want %q", tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+func TestRequestCountry(t *testing.T) {
+	tests := []struct {
+		header http.Header
+		want   string
+	}{
+		{http.Header{}, "unknown"},
+		{http.Header{"Cf-Ipcountry": {"de"}}, "DE"},
+		{http.Header{"Cf-Ipcountry": {"XX"}, "X-Country-Code": {"FR"}}, "unknown"},
+		{http.Header{"Cloudfront-Viewer-Country": {"JP"}, "X-Country-Code": {"FR"}}, "JP"},
+	}
+	for _, tt := range tests {
+		if got := requestCountry(tt.header); got != tt.want {
+			t.Errorf("requestCountry(%v) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSecondaryTransaction(t *testing.T) {
+	primary, _ := nrtest.NewApplication(t, "primary")
+	defer primary.Shutdown(time.Second)
+	secondary, c := nrtest.NewApplication(t, "secondary")
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(nrgin.Middleware(primary), secondaryTransaction(secondary))
+	router.GET("/users/:id", func(c *gin.Context) {
+		nrgin.Transaction(c).SetName("GET /users/:id")
+		c.Status(http.StatusTeapot)
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/42", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/no/such/route", nil))
+	secondary.Shutdown(5 * time.Second)
+
+	names := c.MetricNames()
+	if !names["WebTransaction/Go/GET /users/:id"] {
+		t.Errorf("the secondary application recorded no transaction named after the primary one: %v", names)
+	}
+	if !names["WebTransaction/Go/NotFound"] || names["WebTransaction/Go/GET "] {
+		t.Errorf("want the unmatched request recorded as NotFound: %v", names)
+	}
+	if want := `"http.statusCode":418`; !bytes.Contains(c.Payload("analytic_event_data"), []byte(want)) {
+		t.Errorf("the secondary transaction lacks %s", want)
+	}
+
+	//without a secondary application the middleware only passes the request on
+	router = newTestRouter(t, secondaryTransaction(nil))
+	router.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/ok", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestCaptureAttributesName(t *testing.T) {
+	app, err := newrelic.NewApplication(newrelic.ConfigAppName("test"), newrelic.ConfigEnabled(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	h := handlers.New(app, logger, handlers.Deps{})
+
+	var name string
+	router := newTestRouter(t, captureAttributes())
+	router.GET("/users/:id", func(c *gin.Context) {
+		h.User(c)
+		name = nrgin.Transaction(c).Name()
+	})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/users/42", nil))
+	if rec.Body.String() != `{"id":"42"}` {
+		t.Errorf("body = %q, want %q", rec.Body.String(), `{"id":"42"}`)
+	}
+	if want := "GET /users/:id"; name != want {
+		t.Errorf("transaction name = %q, want the route template %q", name, want)
+	}
+}
@@ -0,0 +1,334 @@
+package server
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	nrgin "github.com/newrelic/go-agent/v3/integrations/nrgin"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"golang.org/x/time/rate"
+
+	"NewRelics-POC/config"
+	"NewRelics-POC/handlers"
+)
+
+//staticFiles are the assets served under /static
+//
+//go:embed static
+var staticFiles embed.FS
+
+//NewRouter registers the example routes on a gin engine instrumented with app
+func NewRouter(cfg config.Config, app *newrelic.Application, h *handlers.Handler) *gin.Engine {
+	//gin.New rather than gin.Default, request lines go through our own logger
+	router := gin.New()
+	router.Use(gin.Recovery())
+	//believe X-Forwarded-For only from TRUSTED_PROXIES, so c.ClientIP is the real client behind a load balancer
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		h.Logger.WithError(err).Error("invalid trusted proxies")
+	} else if len(cfg.TrustedProxies) == 0 {
+		h.Logger.Info("no trusted proxies, the connecting address is the client IP")
+	} else {
+		h.Logger.WithField("trusted_proxies", cfg.TrustedProxies).Info("trusting X-Forwarded-For from proxies")
+	}
+	//probes are registered before the middleware so they do not create transactions
+	router.GET("/healthz", h.Healthz)
+	router.GET("/readyz", h.Readyz)
+	//Prometheus scrapes, also kept out of New Relic
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	//a plain http.HandlerFunc that starts its own transaction, so it too comes before the middleware
+	router.GET("/manual", gin.WrapF(h.Manual))
+	//define new relics middleware, or trace with OpenTelemetry when TELEMETRY_MODE=otel
+	if cfg.TelemetryMode == config.TelemetryOTel {
+		router.Use(otelgin.Middleware(cfg.AppName))
+	} else {
+		router.Use(nrgin.Middleware(app))
+	}
+	//let handlers and instrumented clients find the transaction on the request context
+	router.Use(transactionContext())
+	//count the requests in flight for Custom/InFlightRequests and the shutdown log
+	router.Use(inFlightRequests(&inFlight))
+	//log every request with its trace id, in the LOG_LEVEL and LOG_FORMAT set up by main
+	router.Use(requestLogger(h.Logger))
+	//report to a second account as well when one is configured
+	router.Use(secondaryTransaction(h.Secondary))
+	//tag every transaction with APP_ENV, labels alone cannot be queried per event
+	router.Use(environment(cfg.Environment))
+	//record the client's locale and country from Accept-Language and CDN headers
+	router.Use(locale())
+	//record path parameters such as :id as route.* attributes
+	router.Use(routeParams())
+	//tag the request and its transaction with an X-Request-ID
+	router.Use(requestID())
+	//verify bearer tokens with JWT_SECRET and record their user, roles and tenant
+	router.Use(jwtAuth(cfg.JWTSecret))
+	//attribute the transaction to the calling user, from verified tokens only once JWT_SECRET is set
+	router.Use(userID(cfg.JWTSecret != ""))
+	//drop transactions for noisy paths listed in NEW_RELIC_IGNORE_PATHS
+	router.Use(ignorePaths(cfg.IgnorePaths))
+	//keep the start of error response bodies on the transaction
+	router.Use(captureErrorBody(cfg.ErrorBodyLimit))
+	//report requests slower than SLOW_REQUEST_MS
+	router.Use(slowRequests(cfg.SlowRequestThreshold, h.Secondary))
+	//score requests against APDEX_T, or the route's entry in APDEX_T_ROUTES
+	router.Use(apdexZone(cfg.ApdexThreshold, cfg.RouteApdex))
+	//record request/response attributes, placed before recovery so panics are seen as 500s
+	router.Use(captureAttributes())
+	//rename transactions from X-Transaction-Name, only when ALLOW_TXN_NAME_OVERRIDE is set
+	router.Use(transactionName(cfg.AllowTxnNameOverride))
+	//notice the binding and validation errors handlers add to c.Errors
+	router.Use(bindingErrors())
+	//report panics to New Relic, must come after the New Relic middleware
+	router.Use(recoverWithNewRelic())
+	//cap request bodies at MAX_BODY_BYTES, /upload enforces UPLOAD_MAX_BYTES itself
+	router.Use(maxBody(int64(cfg.MaxBodyBytes), h.Secondary, "/upload"))
+	//bound every request by REQUEST_TIMEOUT
+	router.Use(requestTimeout(cfg.RequestTimeout))
+	//routes listed in DISABLED_ROUTES are left out below and answer 404
+	routes := newRouteFilter(router, cfg.DisabledRoutes)
+	if len(cfg.DisabledRoutes) > 0 {
+		h.Logger.WithField("disabled_routes", cfg.DisabledRoutes).Info("leaving out disabled routes")
+	}
+	//static assets, their transactions are ignored so they do not dominate throughput
+	assets, _ := fs.Sub(staticFiles, "static")
+	router.Group("/static", ignoreTransaction()).StaticFS("/", http.FS(assets))
+	//Example APIs
+	//set the transaction
+	routes.GET("/txn", h.EndpointAccessTransaction)
+	//test the connection
+	routes.GET("/test-connection", h.Index)
+	//check the version of new relics being used and of this build
+	routes.GET("/version", h.Version)
+	//which entity and host served the request
+	routes.GET("/whoami", h.Whoami)
+	//notice the error
+	routes.GET("/notice_error", h.NoticeError)
+	//test the error with attributes
+	routes.GET("/notice_error_with_attributes", h.NoticeErrorWithAttributes)
+	//notice an expected error on a successful request
+	routes.GET("/expected_error", h.ExpectedError)
+	//the same failure noticed as an error and forwarded as an error log line
+	routes.GET("/error_vs_log", h.ErrorVsLog)
+	//record a log event straight through the agent, linked to the trace
+	routes.GET("/log", h.Log)
+	//add the custom events
+	routes.GET("/custom_event", h.CustomEvent)
+	//call /segments and /custom_event of this service, continuing the trace in both
+	routes.GET("/composite", h.Composite)
+	//a custom event built from the query string
+	routes.GET("/event", h.DynamicEvent)
+	//set name for transaction
+	routes.GET("/set_name", h.SetName)
+	//add attribute to transaction
+	routes.GET("/add_attribute", h.AddAttribute)
+	//end the transaction halfway through the handler and keep using it
+	routes.GET("/early_end", h.EarlyEnd)
+	//set which transation should get igored
+	routes.GET("/ignore", h.Ignore)
+	//add segment to the function
+	routes.GET("/segments", h.Segments)
+	//nest ?depth= segments to check how deep traces render
+	routes.GET("/segments_tree", h.SegmentsTree)
+	//deferred, explicit and never-ended segments side by side
+	routes.GET("/segment_lifecycle", h.SegmentLifecycle)
+	//segments started by the generic instrument helper
+	routes.GET("/instrumented", h.Instrumented)
+	//three middleware layers and the handler adding to the one transaction
+	routes.GET("/chain", handlers.ChainLayer("auth"), handlers.ChainLayer("tenant"), handlers.ChainLayer("audit"), h.Chain)
+	//segments tagged with a category attribute
+	routes.GET("/categorized", h.Categorized)
+	//add attributes to individual spans
+	routes.GET("/span_attributes", h.SpanAttributes)
+	//add transatio to external APIs
+	routes.GET("/external", h.External)
+	//the same call with a hand-made external segment
+	routes.GET("/external_manual", h.ExternalManual)
+	//join the trace of the caller from its inbound headers
+	routes.GET("/continue_trace", h.ContinueTrace)
+	//call the DOWNSTREAM_URLS in turn, one external segment each
+	routes.GET("/ping-downstream", h.PingDownstream)
+	//add metrics
+	routes.GET("/custommetric", h.CustomMetric)
+	//record several samples of one metric to show how they aggregate
+	routes.GET("/timing", h.Timing)
+	//browser recoard
+	routes.GET("/browser", h.Browser)
+	//transation in go routine
+	routes.GET("/async", h.Async)
+	//hand work to a goroutine in a linked transaction instead of sharing this one
+	routes.GET("/linked_async", h.LinkedAsync)
+	//many goroutines sharing one transaction
+	routes.GET("/fanout", h.Fanout)
+	//add mesage o the segment
+	routes.GET("/message", h.Message)
+	//consume a message in its own transaction
+	routes.GET("/consume", h.Consume)
+	//process a message, retrying failed attempts and dead-lettering it once retries run out
+	routes.GET("/process_message", h.ProcessMessage)
+	//queue a job for the background worker pool
+	routes.POST("/enqueue", h.Enqueue)
+	//respond after an artificial delay
+	routes.GET("/slow", h.Slow)
+	//burn CPU to compare CPU-bound and I/O-bound slow transactions
+	routes.GET("/load", h.Load)
+	//time the same workload with and without segments to measure the agent's overhead
+	routes.GET("/compare", h.Compare)
+	//stream server-sent events, a long-lived transaction
+	routes.GET("/stream", h.Stream)
+	//token-bucket limited, throttled requests get a 429
+	routes.GET("/limited", rateLimit(rate.NewLimiter(rate.Limit(cfg.RateLimit), cfg.RateBurst)), h.Limited)
+	//panic inside a handler
+	routes.GET("/panic", h.Panic)
+	//fail a share of the requests to exercise alert policies
+	routes.GET("/simulate", h.Simulate)
+	//parameterized route, named by its template
+	routes.GET("/users/:id", h.User)
+	//look up an item, its :category and :id become route.category and route.id
+	routes.GET("/items/:category/:id", h.Item)
+	//create an order from a JSON body, retries repeating an Idempotency-Key get the first response
+	routes.POST("/orders", idempotency(newIdempotencyCache(cfg.IdempotencyCacheSize, cfg.IdempotencyTTL)), h.CreateOrder)
+	//process a JSON array of items, reporting partial failures
+	routes.POST("/batch", h.Batch)
+	//segments started by the service layer from the context
+	routes.GET("/service_demo", h.ServiceDemo)
+	//save a multipart file upload
+	routes.POST("/upload", h.Upload)
+	//query the database through nrmysql
+	if h.DB != nil {
+		routes.GET("/db/users", h.DBUsers)
+	}
+	//query PostgreSQL through nrpgx5
+	if h.PG != nil {
+		routes.GET("/pg/time", h.PGTime)
+	}
+	//read and write Redis through nrredis
+	if h.Redis != nil {
+		routes.GET("/cache/:key", h.GetCache)
+		routes.PUT("/cache/:key", h.SetCache)
+	}
+	//developer utilities, only when ENABLE_DEBUG_ROUTES is set
+	if cfg.DebugRoutes {
+		//echo the distributed trace headers of the current transaction
+		routes.GET("/trace", h.Trace)
+		//report the effective agent configuration
+		routes.GET("/config", h.AgentConfig)
+		//return the trace, span and entity ids of the current transaction
+		routes.GET("/trace_dump", h.TraceDump)
+		//record an event to look up once the next harvest has run
+		routes.GET("/flush", h.Flush)
+		//list the downstream services and probe each one, their connection strings are masked
+		routes.GET("/dependencies", h.Dependencies)
+		//report the module, Go version and VCS revision embedded in the binary
+		routes.GET("/buildinfo", h.BuildInfo)
+	}
+	//a typo in DISABLED_ROUTES would otherwise leave the route on unnoticed
+	if unmatched := routes.unmatched(); len(unmatched) > 0 {
+		h.Logger.WithField("disabled_routes", unmatched).Warn("DISABLED_ROUTES entries matched no route")
+	}
+	return router
+}
+
+/*
+routeFilter registers routes on the embedded IRoutes unless they are
+disabled, so an example can be taken offline in one environment without a
+redeploy. An entry such as /cache/:key disables the path for every method,
+"PUT /cache/:key" only that method. Disabled routes are never registered,
+requests for them get the router's 404 like any unknown path.
+
+Only routes registered through the filter can be disabled: the probes,
+/metrics, /manual and /static are mounted on the router directly.
+*/
+type routeFilter struct {
+	gin.IRoutes
+	disabled map[string]bool
+	matched  map[string]bool
+	entries  []string
+}
+
+//newRouteFilter filters the routes registered on routes by the config.DisabledRoutes entries
+func newRouteFilter(routes gin.IRoutes, entries []string) *routeFilter {
+	r := &routeFilter{IRoutes: routes, disabled: map[string]bool{}, matched: map[string]bool{}, entries: entries}
+	for _, entry := range entries {
+		r.disabled[entry] = true
+	}
+	return r
+}
+
+//unmatched returns the entries that disabled no route registered so far
+func (r *routeFilter) unmatched() []string {
+	var unmatched []string
+	for _, entry := range r.entries {
+		if !r.matched[entry] {
+			unmatched = append(unmatched, entry)
+		}
+	}
+	return unmatched
+}
+
+func (r *routeFilter) GET(path string, handlers ...gin.HandlerFunc) gin.IRoutes {
+	return r.Handle(http.MethodGet, path, handlers...)
+}
+
+func (r *routeFilter) POST(path string, handlers ...gin.HandlerFunc) gin.IRoutes {
+	return r.Handle(http.MethodPost, path, handlers...)
+}
+
+func (r *routeFilter) PUT(path string, handlers ...gin.HandlerFunc) gin.IRoutes {
+	return r.Handle(http.MethodPut, path, handlers...)
+}
+
+func (r *routeFilter) DELETE(path string, handlers ...gin.HandlerFunc) gin.IRoutes {
+	return r.Handle(http.MethodDelete, path, handlers...)
+}
+
+//Handle registers path for method unless the path or the method and path are disabled
+func (r *routeFilter) Handle(method, path string, handlers ...gin.HandlerFunc) gin.IRoutes {
+	disabled := false
+	for _, entry := range []string{path, method + " " + path} {
+		if r.disabled[entry] {
+			r.matched[entry] = true
+			disabled = true
+		}
+	}
+	if disabled {
+		return r
+	}
+	r.IRoutes.Handle(method, path, handlers...)
+	return r
+}
+
+/*
+Run serves handler on addr until SIGINT or SIGTERM is received, then stops
+accepting requests and waits up to timeout for in-flight ones to finish.
+*/
+func Run(addr string, handler http.Handler, timeout time.Duration, logger *logrus.Logger) {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.WithError(err).Fatal("server error")
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	logger.WithField("in_flight", inFlight.Load()).Info("shutting down, draining in-flight requests")
+	if err := server.Shutdown(ctx); err != nil {
+		logger.WithError(err).WithField("in_flight", inFlight.Load()).Error("server shutdown error")
+	}
+}
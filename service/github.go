@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//GitHubUser is the part of a GitHub user profile the app uses
+type GitHubUser struct {
+	Login       string `json:"login"`
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Company     string `json:"company"`
+	PublicRepos int    `json:"public_repos"`
+	Followers   int    `json:"followers"`
+}
+
+//StatusError is returned when GitHub answers with a non-2xx status
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "github answered " + e.Status
+}
+
+/*
+GitHubClient calls the GitHub API with the request context. The trace comes
+from Client: with an instrumented client such as the one made by
+handlers.NewSafeClient, every request is an external segment of the
+transaction on the context and carries the distributed trace headers.
+*/
+type GitHubClient struct {
+	//BaseURL is the API root, e.g. https://api.github.com
+	BaseURL string
+	//Client sends the requests
+	Client *http.Client
+	//MaxAttempts bounds how many times a request is tried on network errors and 5xx answers
+	MaxAttempts int
+}
+
+//NewGitHubClient returns a GitHubClient for baseURL, with an agent round tripper when client is nil
+func NewGitHubClient(baseURL string, client *http.Client) *GitHubClient {
+	if client == nil {
+		client = &http.Client{Transport: newrelic.NewRoundTripper(nil)}
+	}
+	return &GitHubClient{BaseURL: baseURL, Client: client, MaxAttempts: 1}
+}
+
+//GetUser fetches the profile of login
+func (g *GitHubClient) GetUser(ctx context.Context, login string) (*GitHubUser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", g.BaseURL+"/users/"+url.PathEscape(login), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := doWithRetry(ctx, g.Client, req, g.MaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	var user GitHubUser
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, fmt.Errorf("decoding github user %s: %w", login, err)
+	}
+	return &user, nil
+}
+
+//retryBackoff is the wait before the first retry, doubled after every attempt
+var retryBackoff = 100 * time.Millisecond
+
+/*
+doWithRetry sends req up to maxAttempts times, retrying on network errors and
+5xx responses with exponential backoff. Every attempt goes through client, so
+with an instrumented client each one is its own external segment and retries
+show up in the trace. The retries used are recorded as Custom/ExternalRetries.
+Requests with a body must set GetBody to be retried.
+*/
+func doWithRetry(ctx context.Context, client *http.Client, req *http.Request, maxAttempts int) (*http.Response, error) {
+	backoff := retryBackoff
+	var resp *http.Response
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		attemptReq := req.Clone(ctx)
+		if req.GetBody != nil {
+			if attemptReq.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		resp, err = client.Do(attemptReq)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			break
+		}
+		if attempt >= maxAttempts || (req.Body != nil && req.GetBody == nil) {
+			break
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	if app := newrelic.FromContext(ctx).Application(); app != nil {
+		app.RecordCustomMetric("ExternalRetries", float64(attempt-1))
+	}
+	return resp, err
+}
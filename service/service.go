@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+//User is a user as returned by the service layer
+type User struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+//Order is one of a user's orders
+type Order struct {
+	ID    string  `json:"id"`
+	Total float64 `json:"total"`
+}
+
+/*
+Service is the example business layer. Its methods take a context.Context
+rather than a *newrelic.Transaction and start their own segments from the
+transaction found on it, so they are traced when called from a request and
+still work when the context carries none.
+*/
+type Service struct{}
+
+//New returns a Service
+func New() *Service {
+	return &Service{}
+}
+
+//FetchUser loads a user by id
+func (s *Service) FetchUser(ctx context.Context, id string) (User, error) {
+	defer newrelic.FromContext(ctx).StartSegment("service.FetchUser").End()
+	time.Sleep(5 * time.Millisecond)
+	return User{ID: id, Name: "user " + id}, nil
+}
+
+//FetchOrders loads the orders of a user
+func (s *Service) FetchOrders(ctx context.Context, userID string) ([]Order, error) {
+	defer newrelic.FromContext(ctx).StartSegment("service.FetchOrders").End()
+	time.Sleep(10 * time.Millisecond)
+	return []Order{
+		{ID: fmt.Sprintf("%s-1", userID), Total: 9.5},
+		{ID: fmt.Sprintf("%s-2", userID), Total: 20},
+	}, nil
+}
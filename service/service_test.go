@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//the service must work without a transaction on the context
+func TestServiceWithoutTransaction(t *testing.T) {
+	svc := New()
+	u, err := svc.FetchUser(context.Background(), "42")
+	if err != nil || u.ID != "42" {
+		t.Errorf("FetchUser(42) = %+v, %v, want user 42", u, err)
+	}
+	orders, err := svc.FetchOrders(context.Background(), "42")
+	if err != nil || len(orders) == 0 {
+		t.Errorf("FetchOrders(42) = %+v, %v, want orders", orders, err)
+	}
+}
+
+func TestDoWithRetry(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	req, _ := http.NewRequest("GET", upstream.URL, nil)
+	resp, err := doWithRetry(context.Background(), upstream.Client(), req, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls != 3 {
+		t.Errorf("status = %d after %d calls, want %d after 3", resp.StatusCode, calls, http.StatusOK)
+	}
+
+	calls = 0
+	resp, err = doWithRetry(context.Background(), upstream.Client(), req, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || calls != 2 {
+		t.Errorf("status = %d after %d calls, want %d after 2", resp.StatusCode, calls, http.StatusServiceUnavailable)
+	}
+}
+
+func TestGitHubClient(t *testing.T) {
+	var accept string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept")
+		switch r.URL.Path {
+		case "/users/defunkt":
+			io.WriteString(w, `{"login":"defunkt","id":2,"name":"Chris Wanstrath","public_repos":107}`)
+		case "/users/broken":
+			io.WriteString(w, `{"login":`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+	client := NewGitHubClient(upstream.URL, upstream.Client())
+
+	user, err := client.GetUser(context.Background(), "defunkt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (GitHubUser{Login: "defunkt", ID: 2, Name: "Chris Wanstrath", PublicRepos: 107}); *user != want {
+		t.Errorf("GetUser(defunkt) = %+v, want %+v", *user, want)
+	}
+	if accept != "application/vnd.github+json" {
+		t.Errorf("Accept = %q, want the GitHub media type", accept)
+	}
+
+	var status *StatusError
+	if _, err := client.GetUser(context.Background(), "nobody"); !errors.As(err, &status) || status.StatusCode != http.StatusNotFound {
+		t.Errorf("GetUser(nobody) error = %v, want a 404 StatusError", err)
+	}
+	if _, err := client.GetUser(context.Background(), "broken"); err == nil {
+		t.Error("GetUser(broken) succeeded, want a decoding error")
+	}
+}
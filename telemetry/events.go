@@ -0,0 +1,17 @@
+package telemetry
+
+import "github.com/newrelic/go-agent/v3/newrelic"
+
+/*
+RecordEvent records a custom event to every application given, usually the
+primary one and, while dual-reporting during an account migration, the
+secondary one. Nil applications, such as an unconfigured secondary, are
+skipped.
+*/
+func RecordEvent(eventType string, params map[string]interface{}, apps ...*newrelic.Application) {
+	for _, app := range apps {
+		if app != nil {
+			app.RecordCustomEvent(eventType, params)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package telemetry
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+//New Relic's OTLP ingest endpoint, used unless OTEL_EXPORTER_OTLP_ENDPOINT is set
+const newRelicOTLPEndpoint = "otlp.nr-data.net:4318"
+
+/*
+SetupOTel installs a global tracer provider that exports spans to New Relic
+over OTLP/HTTP, authenticated with the license key. The standard OTEL_*
+variables still apply, so the exporter can point at a collector instead.
+The returned function flushes and stops the provider.
+*/
+func SetupOTel(ctx context.Context, serviceName, license string) (func(context.Context) error, error) {
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithHeaders(map[string]string{"api-key": license}),
+	}
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		opts = append(opts, otlptracehttp.WithEndpoint(newRelicOTLPEndpoint))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,15 @@
+/*
+Package version holds build metadata injected at link time, e.g.
+
+	go build -ldflags "-X NewRelics-POC/version.Version=1.2.0 \
+		-X NewRelics-POC/version.GitCommit=$(git rev-parse --short HEAD) \
+		-X NewRelics-POC/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+*/
+package version
+
+//set with -ldflags "-X", the defaults mark a local build
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)